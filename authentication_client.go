@@ -63,6 +63,12 @@ func (client *AuthenticationClient) SetRegion(region string) {
 // AuthenticateClient takes in a request to authenticate a client, makes the API request to OCI Identity and returns the Response.
 // If the authentication is successful, the AuthenticateClientResult member of the response will contain the Principal of the authenticated entity.
 func (client AuthenticationClient) AuthenticateClient(ctx context.Context, request AuthenticateClientRequest) (response AuthenticateClientResponse, err error) {
+	ctx, span := startSpan(ctx, "oci.identity.AuthenticateClient")
+	defer func() {
+		span.SetAttributes(attributeOpcRequestId.String(opcRequestIdOfResponse(response.OpcRequestId, err)))
+		endSpan(span, err)
+	}()
+
 	var ociResponse common.OCIResponse
 	policy := common.NoRetryPolicy()
 	if request.RetryPolicy() != nil {
@@ -93,6 +99,7 @@ func (client AuthenticationClient) authenticateClient(ctx context.Context, reque
 	if err != nil {
 		return nil, err
 	}
+	injectTraceContext(ctx, httpRequest.Header)
 
 	var response AuthenticateClientResponse
 	var httpResponse *http.Response
@@ -111,6 +118,12 @@ func (client AuthenticationClient) authenticateClient(ctx context.Context, reque
 // FilterGroupMembership takes in a list of Group or Dynamic Group IDs and a Principal context and makes an API request to OCI Identity.
 // If the request is successful, it returns the subset of the request groups that the entity corresponding to the Principal is a part of.
 func (client AuthenticationClient) FilterGroupMembership(ctx context.Context, request FilterGroupMembershipRequest) (response FilterGroupMembershipResponse, err error) {
+	ctx, span := startSpan(ctx, "oci.identity.FilterGroupMembership")
+	defer func() {
+		span.SetAttributes(attributeOpcRequestId.String(opcRequestIdOfResponse(response.OpcRequestId, err)))
+		endSpan(span, err)
+	}()
+
 	var ociResponse common.OCIResponse
 	policy := common.NoRetryPolicy()
 	if request.RetryPolicy() != nil {
@@ -141,6 +154,7 @@ func (client AuthenticationClient) filterGroupMembership(ctx context.Context, re
 	if err != nil {
 		return nil, err
 	}
+	injectTraceContext(ctx, httpRequest.Header)
 
 	var response FilterGroupMembershipResponse
 	var httpResponse *http.Response
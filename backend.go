@@ -4,9 +4,13 @@ package ociauth
 import (
 	"context"
 	"fmt"
+	"os"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/vault-plugin-auth-oci/version"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/oracle/oci-go-sdk/v65/common/auth"
@@ -32,19 +36,45 @@ type backend struct {
 	// Lock to make changes to authClient entries
 	authClientMutex sync.RWMutex
 
-	// The client used to authenticate with OCI Identity
+	// The client used to authenticate with OCI Identity, and the auth_mode it was created for
 	authenticationClient *AuthenticationClient
+	authClientMode       string
+
+	// Rejects login floods before they reach OCI Identity
+	loginLimiter *loginLimiter
+
+	// Lock to make changes to the login state of principals
+	loginStateMutex sync.Mutex
+
+	// Lock to make changes to the login ledger, and the time it was last pruned
+	ledgerMutex     sync.Mutex
+	lastLedgerPrune time.Time
+
+	// Lock to make changes to the tracked tokens of principals
+	trackedTokensMutex sync.Mutex
+
+	// Lock to record the outcome of the revocations through the token_revocation_address of the config
+	revocationMutex  sync.Mutex
+	revocationStatus revocationStatus
 }
 
 func Backend() (*backend, error) {
-	b := &backend{}
+	b := &backend{
+		loginLimiter: newLoginLimiter(),
+	}
 
 	b.Backend = &framework.Backend{
 		Help: backendHelp,
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{
+				"login",
 				"login/*",
 			},
+			LocalStorage: []string{
+				loginStateStoragePrefix,
+				ledgerStoragePrefix,
+				trackedTokensStoragePrefix,
+			},
 		},
 		Paths: []*framework.Path{
 			pathLogin(b),
@@ -52,8 +82,26 @@ func Backend() (*backend, error) {
 			pathRole(b),
 			pathListRoles(b),
 			pathConfig(b),
+			pathDenyKeys(b),
+			pathListDenyKeys(b),
+			pathDenyPrincipals(b),
+			pathListDenyPrincipals(b),
+			pathLockout(b),
+			pathListLockouts(b),
+			pathIntrospect(b),
+			pathInfo(b),
+			pathPrincipals(b),
+			pathListPrincipals(b),
+			pathRolesUsage(b),
+			pathTokens(b),
+			pathListTokens(b),
+			pathOCIEvents(b),
 		},
-		BackendType: logical.TypeCredential,
+		AuthRenew:      b.pathLoginRenew,
+		PeriodicFunc:   b.periodicFunc,
+		Invalidate:     b.Invalidate,
+		BackendType:    logical.TypeCredential,
+		RunningVersion: version.Version,
 	}
 
 	return b, nil
@@ -79,13 +127,20 @@ func (b *backend) getOrCreateAuthClient(ctx context.Context, storage logical.Sto
 
 	var configProvider common.ConfigurationProvider
 
-	// Default to instance principal if no config or auth_mode not specified
-	if config == nil || config.AuthMode == "" || config.AuthMode == "instance" {
+	authMode := configuredAuthMode(config)
+
+	if authMode == "instance" {
 		configProvider, err = b.createInstancePrincipalProvider()
-	} else if config.AuthMode == "apikey" {
+	} else if authMode == "apikey" {
 		configProvider, err = b.createAPIKeyProvider(config)
+	} else if authMode == "signer" {
+		configProvider, err = b.createExternalSignerProvider(config)
+	} else if authMode == "resource_principal" {
+		configProvider, err = b.createResourcePrincipalProvider()
+	} else if authMode == "oke_workload_identity" {
+		configProvider, err = b.createOkeWorkloadIdentityProvider()
 	} else {
-		return nil, fmt.Errorf("invalid auth_mode: %s", config.AuthMode)
+		return nil, fmt.Errorf("invalid auth_mode: %s", authMode)
 	}
 
 	if err != nil {
@@ -99,11 +154,26 @@ func (b *backend) getOrCreateAuthClient(ctx context.Context, storage logical.Sto
 		return nil, fmt.Errorf("unable to create authenticationClient: %w", err)
 	}
 
+	// An external signer holds the private key, so it replaces the SDK's key based signer
+	if signerProvider, ok := configProvider.(*externalSignerConfigurationProvider); ok {
+		authenticationClient.Signer = signerProvider.requestSigner()
+	}
+
 	b.authenticationClient = &authenticationClient
+	b.authClientMode = authMode
 
 	return b.authenticationClient, nil
 }
 
+// configuredAuthMode returns the auth_mode of the config, defaulting to instance principal
+// if there is no config or auth_mode is not specified
+func configuredAuthMode(config *OCIConfigEntry) string {
+	if config != nil && config.AuthMode != "" {
+		return config.AuthMode
+	}
+	return "instance"
+}
+
 // createInstancePrincipalProvider creates an instance principal configuration provider
 func (b *backend) createInstancePrincipalProvider() (common.ConfigurationProvider, error) {
 	ip, err := auth.InstancePrincipalConfigurationProvider()
@@ -114,6 +184,28 @@ func (b *backend) createInstancePrincipalProvider() (common.ConfigurationProvide
 	return ip, nil
 }
 
+// createResourcePrincipalProvider creates a resource principal configuration provider,
+// for Vault running in an OCI service such as Container Instances
+func (b *backend) createResourcePrincipalProvider() (common.ConfigurationProvider, error) {
+	rp, err := auth.ResourcePrincipalConfigurationProvider()
+	if err != nil {
+		b.Logger().Debug("Unable to create ResourcePrincipalConfigurationProvider", "err", err)
+		return nil, fmt.Errorf("unable to create Resource Principal provider. This error typically occurs when Vault is not running in an OCI service that provides a resource principal, such as Container Instances. The OCI_RESOURCE_PRINCIPAL_* environment variables must be set. Original error: %w", err)
+	}
+	return rp, nil
+}
+
+// createOkeWorkloadIdentityProvider creates an OKE workload identity configuration provider,
+// for Vault running as a pod in an OKE cluster
+func (b *backend) createOkeWorkloadIdentityProvider() (common.ConfigurationProvider, error) {
+	wi, err := auth.OkeWorkloadIdentityConfigurationProvider()
+	if err != nil {
+		b.Logger().Debug("Unable to create OkeWorkloadIdentityConfigurationProvider", "err", err)
+		return nil, fmt.Errorf("unable to create OKE Workload Identity provider. This error typically occurs when Vault is not running as a pod in an OKE enhanced cluster. The OCI_RESOURCE_PRINCIPAL_VERSION, OCI_RESOURCE_PRINCIPAL_REGION and KUBERNETES_SERVICE_HOST environment variables and the service account token must be available. Original error: %w", err)
+	}
+	return wi, nil
+}
+
 // createAPIKeyProvider creates an API key configuration provider
 func (b *backend) createAPIKeyProvider(config *OCIConfigEntry) (common.ConfigurationProvider, error) {
 	// Validate required fields
@@ -139,6 +231,67 @@ func (b *backend) createAPIKeyProvider(config *OCIConfigEntry) (common.Configura
 	return provider, nil
 }
 
+// createExternalSignerProvider creates a configuration provider whose requests are signed
+// by an external signer, so that the private key never has to be stored in Vault
+func (b *backend) createExternalSignerProvider(config *OCIConfigEntry) (common.ConfigurationProvider, error) {
+	// Validate required fields
+	if config.TenancyOCID == "" || config.UserOCID == "" ||
+		config.Fingerprint == "" || config.Region == "" {
+		return nil, fmt.Errorf("external signer authentication requires tenancy_ocid, user_ocid, fingerprint, and region")
+	}
+
+	var signer ExternalSigner
+	var err error
+	switch config.SignerType {
+	case SignerTypeKMS:
+		if config.KMSCryptoEndpoint == "" || config.KMSKeyId == "" {
+			return nil, fmt.Errorf("the kms signer requires kms_crypto_endpoint and kms_key_id")
+		}
+		// Calls to KMS are authenticated with the instance principal of the Vault host
+		var ip common.ConfigurationProvider
+		ip, err = b.createInstancePrincipalProvider()
+		if err != nil {
+			return nil, err
+		}
+		signer, err = newKMSSigner(ip, config.KMSCryptoEndpoint, config.KMSKeyId, config.KMSKeyVersionId)
+	case SignerTypeCommand:
+		signer, err = newCommandSigner(os.Getenv(envVarSignerCommand))
+	case SignerTypeSocket:
+		signer, err = newSocketSigner(os.Getenv(envVarSignerSocket))
+	default:
+		return nil, fmt.Errorf("invalid signer_type: %s", config.SignerType)
+	}
+	if err != nil {
+		b.Logger().Debug("Unable to create external signer", "err", err)
+		return nil, err
+	}
+
+	return newExternalSignerConfigurationProvider(
+		config.TenancyOCID,
+		config.UserOCID,
+		config.Region,
+		config.Fingerprint,
+		signer,
+	), nil
+}
+
+// periodicFunc prunes the login ledger once per ledgerPruneInterval, and re-validates the principals with
+// tracked tokens. Standbys that can not write to storage leave it to the active node.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	if time.Since(b.lastLedgerPrune) >= ledgerPruneInterval {
+		if err := b.pruneLedger(ctx, req.Storage); err != nil {
+			return err
+		}
+		b.lastLedgerPrune = time.Now()
+	}
+
+	return b.revalidateTokens(ctx, req)
+}
+
 // Invalidate cached clients whenever the configuration changes
 func (b *backend) Invalidate(ctx context.Context, key string) {
 	// Reset the auth client to force recreation with new config
@@ -147,6 +300,7 @@ func (b *backend) Invalidate(ctx context.Context, key string) {
 		defer b.authClientMutex.Unlock()
 
 		b.authenticationClient = nil
+		b.authClientMode = ""
 	}
 }
 
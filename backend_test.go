@@ -3,8 +3,14 @@ package ociauth
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -257,6 +263,14 @@ func makeRequestAndValidateResponse(t *testing.T, cmdMap map[string]string, expe
 
 	response, err := backend.HandleRequest(context.Background(), loginRequest)
 
+	// Failed logins are returned as errors with the HTTP status of their class
+	if codedErr, ok := err.(logical.HTTPCodedError); ok && expectFailure {
+		if status := codedErr.Code(); status != http.StatusBadRequest && status != http.StatusForbidden {
+			t.Fatalf("Expected the login to be rejected, got status %d: %v", status, err)
+		}
+		return
+	}
+
 	if err != nil {
 		t.Fatalf("Test failed, got error: resp:%#v\n err:%v", response, err)
 	}
@@ -295,3 +309,150 @@ func makeRequestAndValidateResponse(t *testing.T, cmdMap map[string]string, expe
 		t.Fatalf("Failed Policy Comparison! Expected Policies: %#v Got Policies: %#v resp: %#v\n", expectedPolicies, response.Auth.Policies, response)
 	}
 }
+
+// writeTestConfig writes a config with the given auth_mode and no other credentials
+func writeTestConfig(t *testing.T, b *backend, storage logical.Storage, authMode string) {
+	t.Helper()
+
+	operation := logical.CreateOperation
+	if existing, _ := b.getOCIConfig(context.Background(), storage); existing != nil {
+		operation = logical.UpdateOperation
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: operation,
+		Path:      "config",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			HomeTenancyIdConfigName: "ocid1.tenancy.oc1..aaaatest",
+			"auth_mode":             authMode,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config write failed. resp:%#v\n err:%v", resp, err)
+	}
+}
+
+// fakeResourcePrincipalEnvironment simulates the environment of an OCI service that provides
+// a v2.2 resource principal, with the session token and private key stored in files
+func fakeResourcePrincipalEnvironment(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	key := generateTestKey(t)
+	keyPath := filepath.Join(dir, "private.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(v interface{}) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	claims := map[string]interface{}{
+		"res_tenant": "ocid1.tenancy.oc1..aaaatest",
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	}
+	token := encode(map[string]string{"alg": "RS256"}) + "." + encode(claims) + "." + base64.RawURLEncoding.EncodeToString([]byte("sig"))
+	tokenPath := filepath.Join(dir, "rpst")
+	if err := os.WriteFile(tokenPath, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OCI_RESOURCE_PRINCIPAL_VERSION", "2.2")
+	t.Setenv("OCI_RESOURCE_PRINCIPAL_RPST", tokenPath)
+	t.Setenv("OCI_RESOURCE_PRINCIPAL_PRIVATE_PEM", keyPath)
+	t.Setenv("OCI_RESOURCE_PRINCIPAL_REGION", "us-phoenix-1")
+}
+
+func TestBackend_ResourcePrincipalAuthMode(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestConfig(t, b, config.StorageView, "resource_principal")
+
+	t.Run("MissingEnvironment", func(t *testing.T) {
+		t.Setenv("OCI_RESOURCE_PRINCIPAL_VERSION", "")
+		if _, err := b.getOrCreateAuthClient(context.Background(), config.StorageView); err == nil {
+			t.Fatal("Expected an error without a resource principal environment")
+		}
+	})
+
+	t.Run("FromEnvironment", func(t *testing.T) {
+		fakeResourcePrincipalEnvironment(t)
+
+		authClient, err := b.getOrCreateAuthClient(context.Background(), config.StorageView)
+		if err != nil {
+			t.Fatalf("getOrCreateAuthClient failed: %v", err)
+		}
+		if authClient.Host != "auth.us-phoenix-1.oraclecloud.com" {
+			t.Fatalf("unexpected host: %s", authClient.Host)
+		}
+
+		// Rewriting the config must drop the cached client
+		writeTestConfig(t, b, config.StorageView, "resource_principal")
+		b.authClientMutex.RLock()
+		cached := b.authenticationClient
+		b.authClientMutex.RUnlock()
+		if cached != nil {
+			t.Fatal("Expected the auth client to be invalidated by the config write")
+		}
+	})
+}
+
+func TestBackend_OkeWorkloadIdentityAuthMode(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestConfig(t, b, config.StorageView, "oke_workload_identity")
+
+	t.Run("MissingEnvironment", func(t *testing.T) {
+		t.Setenv("OCI_RESOURCE_PRINCIPAL_VERSION", "2.2")
+		t.Setenv("OCI_RESOURCE_PRINCIPAL_REGION", "us-phoenix-1")
+		t.Setenv("OCI_KUBERNETES_SERVICE_ACCOUNT_CERT_PATH", filepath.Join(t.TempDir(), "missing-ca.crt"))
+		if _, err := b.getOrCreateAuthClient(context.Background(), config.StorageView); err == nil {
+			t.Fatal("Expected an error without a service account CA certificate")
+		}
+	})
+
+	t.Run("FromEnvironment", func(t *testing.T) {
+		caPath := filepath.Join(t.TempDir(), "ca.crt")
+		if err := os.WriteFile(caPath, []byte{}, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("OCI_RESOURCE_PRINCIPAL_VERSION", "2.2")
+		t.Setenv("OCI_RESOURCE_PRINCIPAL_REGION", "us-phoenix-1")
+		t.Setenv("OCI_KUBERNETES_SERVICE_ACCOUNT_CERT_PATH", caPath)
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+
+		// The provider is created from the environment alone; the service account token
+		// is only exchanged with the cluster when a request is signed
+		provider, err := b.createOkeWorkloadIdentityProvider()
+		if err != nil {
+			t.Fatalf("createOkeWorkloadIdentityProvider failed: %v", err)
+		}
+		if region, _ := provider.Region(); region != "us-phoenix-1" {
+			t.Fatalf("unexpected region: %s", region)
+		}
+	})
+}
@@ -45,6 +45,23 @@ Configuration:
       Enter one of following: 
 		apikey (or) ak		
 		instance (or) ip
+
+  role=<string>
+      Optional name of the role to login with. If not specified, the role is
+      chosen from the group membership of the principal.
+
+  all_roles=<bool>
+      If true and no role is specified, login with all roles the principal
+      qualifies for. The token carries the union of their policies.
+
+  server_id=<string>
+      Optional value of the X-Vault-OCI-Server-ID header to include in the
+      signature. Required when the auth method is configured with
+      server_id_header_value.
+
+  namespace=<string>
+      Optional Vault namespace of the mount, whose X-Vault-Namespace header is
+      included in the signature. Defaults to the namespace of the client.
 `
 	return strings.TrimSpace(help)
 }
@@ -56,19 +73,27 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 	}
 	mount = strings.TrimSuffix(mount, "/")
 
-	role, ok := m["role"]
-	if !ok {
-		return nil, fmt.Errorf("'role' is required")
+	// Without a role, the auth method chooses the role from the group membership of the principal
+	path := fmt.Sprintf(PathLoginFormat, mount)
+	if role, ok := m["role"]; ok && role != "" {
+		path = fmt.Sprintf(PathBaseFormat, mount, strings.ToLower(role))
 	}
-	role = strings.ToLower(role)
-
-	path := fmt.Sprintf(PathBaseFormat, mount, role)
 	signingPath := PathVersionBase + path
 
+	// In a namespace, the signature covers the X-Vault-Namespace header sent by the client
+	if namespace := c.Namespace(); namespace != "" {
+		if _, ok := m["namespace"]; !ok {
+			m["namespace"] = namespace
+		}
+	}
+
 	data, err := CreateLoginData(c.Address(), m, signingPath)
 	if err != nil {
 		return nil, err
 	}
+	if allRoles, ok := m["all_roles"]; ok {
+		data["all_roles"] = allRoles
+	}
 
 	// Now try to login
 	secret, err := c.Logical().Write(path, data)
@@ -85,17 +110,33 @@ func CreateLoginData(addr string, m map[string]string, path string) (map[string]
 		return nil, fmt.Errorf("'auth_type' is required")
 	}
 
-	var headerFunc func(string, string) (http.Header, error)
+	var headerFunc func(string, string, map[string]string) (http.Header, error)
 	switch strings.ToLower(authType) {
 	case "ip", "instance":
-		headerFunc = GetSignedInstanceRequestHeaders
+		headerFunc = getSignedInstanceRequestHeaders
 	case "ak", "apikey":
-		headerFunc = GetSignedAPIRequestHeaders
+		headerFunc = getSignedAPIRequestHeaders
 	default:
 		return nil, fmt.Errorf("unsupported auth_type %q", authType)
 	}
 
-	headers, err := headerFunc(addr, path)
+	// Bind the signature to a Vault server by signing its server ID as well
+	var extraHeaders map[string]string
+	if serverID, ok := m["server_id"]; ok && serverID != "" {
+		extraHeaders = map[string]string{
+			HdrVaultServerID: serverID,
+		}
+	}
+
+	// Bind the signature to the Vault namespace the login is sent to
+	if namespace, ok := m["namespace"]; ok && namespace != "" {
+		if extraHeaders == nil {
+			extraHeaders = map[string]string{}
+		}
+		extraHeaders[HdrVaultNamespace] = namespace
+	}
+
+	headers, err := headerFunc(addr, path, extraHeaders)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +147,10 @@ func CreateLoginData(addr string, m map[string]string, path string) (map[string]
 }
 
 func GetSignedInstanceRequestHeaders(addr, path string) (http.Header, error) {
+	return getSignedInstanceRequestHeaders(addr, path, nil)
+}
+
+func getSignedInstanceRequestHeaders(addr, path string, extraHeaders map[string]string) (http.Header, error) {
 	ip, err := auth.InstancePrincipalConfigurationProvider()
 	if err != nil {
 		return nil, err
@@ -115,26 +160,30 @@ func GetSignedInstanceRequestHeaders(addr, path string) (http.Header, error) {
 	if err != nil {
 		return nil, err
 	}
-	return getSignedRequestHeaders(addr, &c, path)
+	return getSignedRequestHeaders(addr, &c, path, extraHeaders)
 }
 
 func GetSignedAPIRequestHeaders(addr, path string) (http.Header, error) {
+	return getSignedAPIRequestHeaders(addr, path, nil)
+}
+
+func getSignedAPIRequestHeaders(addr, path string, extraHeaders map[string]string) (http.Header, error) {
 	c, err := NewOciClientWithConfigurationProvider(common.DefaultConfigProvider())
 	if err != nil {
 		return nil, err
 	}
 
-	return getSignedRequestHeaders(addr, &c, path)
+	return getSignedRequestHeaders(addr, &c, path, extraHeaders)
 }
 
-func getSignedRequestHeaders(addr string, client *OciClient, path string) (http.Header, error) {
+func getSignedRequestHeaders(addr string, client *OciClient, path string, extraHeaders map[string]string) (http.Header, error) {
 	clientURL, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
 	}
 
 	client.Host = addr
-	request, err := client.ConstructLoginRequest(path)
+	request, err := client.ConstructLoginRequestWithHeaders(path, extraHeaders)
 	if err != nil {
 		return nil, err
 	}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	log "github.com/hashicorp/go-hclog"
@@ -11,6 +12,9 @@ import (
 	ociauth "github.com/hashicorp/vault-plugin-auth-oci"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/plugin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
@@ -21,7 +25,14 @@ func main() {
 	tlsConfig := apiClientMeta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
-	err := plugin.ServeMultiplex(&plugin.ServeOpts{
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.L().Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	err = plugin.ServeMultiplex(&plugin.ServeOpts{
 		BackendFactoryFunc: ociauth.Factory,
 		// set the TLSProviderFunc so that the plugin maintains backwards
 		// compatibility with Vault versions that don’t support plugin AutoMTLS
@@ -29,6 +40,25 @@ func main() {
 	})
 	if err != nil {
 		log.L().Error("plugin shutting down", "error", err)
+		shutdownTracing(context.Background())
 		os.Exit(1)
 	}
 }
+
+// setupTracing exports the spans of the plugin with OTLP over HTTP if an OTLP endpoint is set in the
+// environment of the plugin. Tracing is off otherwise. The exporter is configured with the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tracerProvider)
+	return tracerProvider.Shutdown, nil
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants store the types of the Vault events sent by the plugin
+const (
+	EventTypeLoginSuccess = "oci-auth/login-success"
+	EventTypeLoginFailure = "oci-auth/login-failure"
+	EventTypeRoleWrite    = "oci-auth/role-write"
+	EventTypeConfigWrite  = "oci-auth/config-write"
+)
+
+// sendEvent sends a Vault event with the given metadata pairs. Events are best effort, so errors are only
+// logged, and nothing is sent if the events system is not enabled.
+func (b *backend) sendEvent(ctx context.Context, eventType string, metadataPairs ...string) {
+	err := logical.SendEvent(ctx, b, eventType, metadataPairs...)
+	if err != nil && !errors.Is(err, framework.ErrNoEvents) {
+		b.Logger().Debug("unable to send event", "event_type", eventType, "error", err)
+	}
+}
+
+// loginEventKey is the context key of the details of a login reported in its events
+type loginEventKey struct{}
+
+// loginEvent holds the details of a login that are known so far, for the event sent when it succeeds or fails
+type loginEvent struct {
+	roleName  string
+	subjectId string
+	tenantId  string
+}
+
+// withLoginEvent returns a context that reports the events of a login with the details of the event
+func withLoginEvent(ctx context.Context, event *loginEvent) context.Context {
+	return context.WithValue(ctx, loginEventKey{}, event)
+}
+
+func (e *loginEvent) metadata(req *logical.Request) []string {
+	return []string{
+		"path", req.Path,
+		"role", e.roleName,
+		"subject_id", e.subjectId,
+		"tenant_id", e.tenantId,
+	}
+}
+
+// sendLoginSuccessEvent sends the login-success event of a login
+func (b *backend) sendLoginSuccessEvent(ctx context.Context, req *logical.Request) {
+	if event, ok := ctx.Value(loginEventKey{}).(*loginEvent); ok {
+		b.sendEvent(ctx, EventTypeLoginSuccess, event.metadata(req)...)
+	}
+}
+
+// sendLoginFailureEvent sends the login-failure event of a login with the error code and cause of the failure.
+// Nothing is sent for requests that are not logins, such as alias lookaheads and introspection.
+func (b *backend) sendLoginFailureEvent(ctx context.Context, req *logical.Request, errorCode string, err error) {
+	if event, ok := ctx.Value(loginEventKey{}).(*loginEvent); ok {
+		b.sendEvent(ctx, EventTypeLoginFailure, append(event.metadata(req), "error_code", errorCode, "reason", err.Error())...)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_Events(t *testing.T) {
+	sender := logical.NewMockEventSender()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	config.EventsSender = sender
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestConfig(t, b, config.StorageView, "instance")
+	if err := createRole(map[string]interface{}{"ocid_list": "ocid1"}, "devrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	identity := &fakeIdentity{
+		subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+		principalType: PrincipalTypeInstance,
+		groupIds:      []string{"ocid1"},
+	}
+	useFakeIdentity(t, b, identity)
+
+	login := func(operation logical.Operation, succeeds bool) {
+		headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "devrole"), nil)
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      "login/devrole",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if (err == nil) != succeeds {
+			t.Fatalf("unexpected login result: %v", err)
+		}
+	}
+
+	login(logical.UpdateOperation, true)
+	identity.groupIds = []string{"ocid9"}
+	login(logical.UpdateOperation, false)
+
+	// Alias lookaheads are not reported
+	login(logical.AliasLookaheadOperation, false)
+
+	expected := []struct {
+		eventType string
+		metadata  map[string]string
+	}{
+		{EventTypeConfigWrite, map[string]string{"path": "config", "auth_mode": "instance", "operation": "create"}},
+		{EventTypeRoleWrite, map[string]string{"path": "role/devrole", "role": "devrole", "operation": "create"}},
+		{EventTypeLoginSuccess, map[string]string{
+			"role":       "devrole",
+			"subject_id": "ocid1.instance.oc1.phx.aaaatest",
+			"tenant_id":  "ocid1.tenancy.oc1..aaaatest",
+		}},
+		{EventTypeLoginFailure, map[string]string{
+			"role":       "devrole",
+			"subject_id": "ocid1.instance.oc1.phx.aaaatest",
+			"error_code": ErrorCodeAccessDenied,
+			"reason":     "Entity not a part of any of the Role OCIDs",
+		}},
+	}
+
+	if len(sender.Events) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %v", len(expected), len(sender.Events), sender.Events)
+	}
+	for i, event := range sender.Events {
+		if string(event.Type) != expected[i].eventType {
+			t.Fatalf("expected event %d to be %s, got %s", i, expected[i].eventType, event.Type)
+		}
+		metadata := event.Event.Metadata.AsMap()
+		for key, value := range expected[i].metadata {
+			if metadata[key] != value {
+				t.Fatalf("expected %s of the %s event to be %q, got %v", key, event.Type, value, metadata[key])
+			}
+		}
+	}
+}
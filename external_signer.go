@@ -0,0 +1,153 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// These constants define the supported external signer types
+const (
+	SignerTypeKMS     = "kms"
+	SignerTypeCommand = "command"
+	SignerTypeSocket  = "socket"
+)
+
+// ExternalSigner signs a SHA-256 digest with RSA PKCS#1 v1.5 using a private key that
+// is never exposed to the plugin, such as a key held in OCI KMS or an HSM.
+type ExternalSigner interface {
+	SignDigest(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// externalSignerConfigurationProvider supplies the identity of an API key user while
+// leaving the private key with an ExternalSigner.
+type externalSignerConfigurationProvider struct {
+	tenancy     string
+	user        string
+	region      string
+	fingerprint string
+	signer      ExternalSigner
+}
+
+func newExternalSignerConfigurationProvider(tenancy, user, region, fingerprint string, signer ExternalSigner) *externalSignerConfigurationProvider {
+	return &externalSignerConfigurationProvider{
+		tenancy:     tenancy,
+		user:        user,
+		region:      region,
+		fingerprint: fingerprint,
+		signer:      signer,
+	}
+}
+
+// PrivateRSAKey returns no key, the signing is done by requestSigner instead.
+// A nil error is returned so that the SDK accepts the provider as valid.
+func (p *externalSignerConfigurationProvider) PrivateRSAKey() (*rsa.PrivateKey, error) {
+	return nil, nil
+}
+
+func (p *externalSignerConfigurationProvider) KeyID() (string, error) {
+	return fmt.Sprintf("%s/%s/%s", p.tenancy, p.user, p.fingerprint), nil
+}
+
+func (p *externalSignerConfigurationProvider) TenancyOCID() (string, error) {
+	return p.tenancy, nil
+}
+
+func (p *externalSignerConfigurationProvider) UserOCID() (string, error) {
+	return p.user, nil
+}
+
+func (p *externalSignerConfigurationProvider) KeyFingerprint() (string, error) {
+	return p.fingerprint, nil
+}
+
+func (p *externalSignerConfigurationProvider) Region() (string, error) {
+	return p.region, nil
+}
+
+func (p *externalSignerConfigurationProvider) AuthType() (common.AuthConfig, error) {
+	return common.AuthConfig{AuthType: common.UnknownAuthenticationType}, nil
+}
+
+// requestSigner returns the signer that must replace the SDK's default signer on clients
+// created with this provider.
+func (p *externalSignerConfigurationProvider) requestSigner() common.HTTPRequestSigner {
+	keyID, _ := p.KeyID()
+	return externalRequestSigner{
+		keyID:  keyID,
+		signer: p.signer,
+	}
+}
+
+// externalRequestSigner implements the same draft-cavage http-signatures scheme as the
+// SDK's default signer, but delegates the RSA operation to an ExternalSigner.
+type externalRequestSigner struct {
+	keyID  string
+	signer ExternalSigner
+}
+
+// Sign sets the Authorization header of the request
+func (s externalRequestSigner) Sign(request *http.Request) error {
+	signingHeaders := common.DefaultGenericHeaders()
+	if request.Method == http.MethodPost || request.Method == http.MethodPut || request.Method == http.MethodPatch {
+		bodyHash, err := common.GetBodyHash(request)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("x-content-sha256", bodyHash)
+		signingHeaders = append(signingHeaders, common.DefaultBodyHeaders()...)
+	}
+
+	digest := sha256.Sum256([]byte(getSigningString(request, signingHeaders)))
+	signature, err := s.signer.SignDigest(request.Context(), digest[:])
+	if err != nil {
+		return fmt.Errorf("external signer failed: %w", err)
+	}
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"Signature version=\"1\",headers=\"%s\",keyId=\"%s\",algorithm=\"rsa-sha256\",signature=\"%s\"",
+		strings.Join(signingHeaders, " "), s.keyID, base64.StdEncoding.EncodeToString(signature)))
+
+	return nil
+}
+
+// getSigningString builds the string covered by the signature from the given headers
+func getSigningString(request *http.Request, signingHeaders []string) string {
+	signingParts := make([]string, len(signingHeaders))
+	for i, name := range signingHeaders {
+		var value string
+		name = strings.ToLower(name)
+		switch name {
+		case HdrRequestTarget:
+			value = fmt.Sprintf("%s %s", strings.ToLower(request.Method), request.URL.RequestURI())
+		case "host":
+			value = request.URL.Host
+			if len(value) == 0 {
+				value = request.Host
+			}
+		default:
+			value = request.Header.Get(name)
+		}
+		signingParts[i] = fmt.Sprintf("%s: %s", name, value)
+	}
+	return strings.Join(signingParts, "\n")
+}
+
+// decodeSignature decodes a base64 signature returned by an external signer
+func decodeSignature(encoded string) ([]byte, error) {
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("signature is not valid base64: %w", err)
+	}
+	if len(signature) == 0 {
+		return nil, fmt.Errorf("signature is empty")
+	}
+	return signature, nil
+}
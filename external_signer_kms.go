@@ -0,0 +1,55 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/keymanagement"
+)
+
+// kmsSigner signs digests with an asymmetric RSA key held in OCI KMS
+type kmsSigner struct {
+	client       keymanagement.KmsCryptoClient
+	keyId        string
+	keyVersionId string
+}
+
+// newKMSSigner creates a signer that calls the given KMS crypto endpoint.
+// The configuration provider is used to authenticate the calls to KMS itself.
+func newKMSSigner(configProvider common.ConfigurationProvider, cryptoEndpoint, keyId, keyVersionId string) (*kmsSigner, error) {
+	client, err := keymanagement.NewKmsCryptoClientWithConfigurationProvider(configProvider, cryptoEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create KMS crypto client: %w", err)
+	}
+
+	return &kmsSigner{
+		client:       client,
+		keyId:        keyId,
+		keyVersionId: keyVersionId,
+	}, nil
+}
+
+func (s *kmsSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	details := keymanagement.SignDataDetails{
+		Message:          common.String(base64.StdEncoding.EncodeToString(digest)),
+		KeyId:            common.String(s.keyId),
+		SigningAlgorithm: keymanagement.SignDataDetailsSigningAlgorithmSha256RsaPkcs1V15,
+		MessageType:      keymanagement.SignDataDetailsMessageTypeDigest,
+	}
+	if s.keyVersionId != "" {
+		details.KeyVersionId = common.String(s.keyVersionId)
+	}
+
+	response, err := s.client.Sign(ctx, keymanagement.SignRequest{SignDataDetails: details})
+	if err != nil {
+		return nil, err
+	}
+	if response.Signature == nil {
+		return nil, fmt.Errorf("KMS returned no signature")
+	}
+
+	return decodeSignature(*response.Signature)
+}
@@ -0,0 +1,96 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The local signers share a line based protocol: the plugin writes the base64 encoded
+// SHA-256 digest followed by a newline, and reads back the base64 encoded RSA PKCS#1 v1.5
+// signature followed by a newline.
+
+// These environment variables of the plugin process hold the command and the socket of the local signers.
+// They are set by the operator who registers the plugin rather than in the config, so that whoever can write
+// the config can not run a command or reach a socket of the Vault host.
+const (
+	envVarSignerCommand = "OCI_SIGNER_COMMAND"
+	envVarSignerSocket  = "OCI_SIGNER_SOCKET"
+)
+
+// defaultSocketSignerTimeout bounds a socket signing exchange when the context has no deadline
+const defaultSocketSignerTimeout = 10 * time.Second
+
+// commandSigner runs a local command for every signature
+type commandSigner struct {
+	args []string
+}
+
+func newCommandSigner(command string) (*commandSigner, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s is empty", envVarSignerCommand)
+	}
+	return &commandSigner{args: args}, nil
+}
+
+func (s *commandSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(digest) + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("signer command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return decodeSignature(stdout.String())
+}
+
+// socketSigner talks to a signing agent listening on a unix socket
+type socketSigner struct {
+	path string
+}
+
+func newSocketSigner(path string) (*socketSigner, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%s is empty", envVarSignerSocket)
+	}
+	return &socketSigner{path: path}, nil
+}
+
+func (s *socketSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to signer socket: %w", err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSocketSignerTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte(base64.StdEncoding.EncodeToString(digest) + "\n")); err != nil {
+		return nil, fmt.Errorf("unable to write to signer socket: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("unable to read from signer socket: %w", err)
+	}
+
+	return decodeSignature(line)
+}
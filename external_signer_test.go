@@ -0,0 +1,148 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// localKeySigner is an ExternalSigner backed by an in-memory key
+type localKeySigner struct {
+	key *rsa.PrivateKey
+}
+
+func (s localKeySigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest)
+}
+
+func generateTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestExternalRequestSigner_Sign(t *testing.T) {
+	key := generateTestKey(t)
+	provider := newExternalSignerConfigurationProvider("ocid1.tenancy.oc1..t", "ocid1.user.oc1..u", "us-phoenix-1", "aa:bb", localKeySigner{key: key})
+
+	request, err := http.NewRequest(http.MethodPost, "https://auth.us-phoenix-1.oraclecloud.com/v1/authentication/authenticateClient", strings.NewReader(`{"requestHeaders":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("date", time.Now().UTC().Format(http.TimeFormat))
+	request.Header.Set("content-type", "application/json")
+
+	if err := provider.requestSigner().Sign(request); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	authorization := request.Header.Get("Authorization")
+	match := regexp.MustCompile(`headers="([^"]*)",keyId="([^"]*)",algorithm="rsa-sha256",signature="([^"]*)"`).FindStringSubmatch(authorization)
+	if match == nil {
+		t.Fatalf("unexpected Authorization header: %s", authorization)
+	}
+	if match[2] != "ocid1.tenancy.oc1..t/ocid1.user.oc1..u/aa:bb" {
+		t.Fatalf("unexpected keyId: %s", match[2])
+	}
+	if match[1] != "date (request-target) host content-length content-type x-content-sha256" {
+		t.Fatalf("unexpected signed headers: %s", match[1])
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(match[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(getSigningString(request, strings.Fields(match[1]))))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+}
+
+func TestCommandSigner_SignDigest(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "signer.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nread digest\necho \"$digest\"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := newCommandSigner(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The script echoes the digest back, so the signature equals the digest
+	signature, err := signer.SignDigest(context.Background(), []byte("digest"))
+	if err != nil {
+		t.Fatalf("SignDigest failed: %v", err)
+	}
+	if string(signature) != "digest" {
+		t.Fatalf("unexpected signature: %q", signature)
+	}
+
+	failing, err := newCommandSigner("/bin/sh -c false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := failing.SignDigest(context.Background(), []byte("digest")); err == nil {
+		t.Fatal("expected an error from a failing signer command")
+	}
+
+	if _, err := newCommandSigner("  "); err == nil {
+		t.Fatal("expected an error for an empty signer command")
+	}
+}
+
+func TestSocketSigner_SignDigest(t *testing.T) {
+	key := generateTestKey(t)
+	socketPath := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		digest, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+		conn.Write([]byte(base64.StdEncoding.EncodeToString(signature) + "\n"))
+	}()
+
+	signer, err := newSocketSigner(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := signer.SignDigest(context.Background(), digest[:])
+	if err != nil {
+		t.Fatalf("SignDigest failed: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+}
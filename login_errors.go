@@ -0,0 +1,170 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// These constants are the machine readable codes of failed logins, returned in the error_code field
+const (
+	// The login request is malformed or does not match the configuration, retrying it does not help
+	ErrorCodeInvalidRequest = "invalid_request"
+
+	// The principal was authenticated but is not allowed to login, or OCI Identity rejected the signature
+	ErrorCodeAccessDenied = "access_denied"
+
+	// The login rate limit was exceeded, retrying later may succeed
+	ErrorCodeRateLimited = "rate_limited"
+
+	// The principal is locked out of the role, retrying after the lockout may succeed
+	ErrorCodeLockedOut = "locked_out"
+
+	// OCI Identity returned an unexpected error
+	ErrorCodeUpstreamError = "upstream_error"
+
+	// OCI Identity could not be reached or is throttling, retrying later may succeed
+	ErrorCodeUpstreamUnavailable = "upstream_unavailable"
+
+	// Vault failed to process the login, such as when reading from storage
+	ErrorCodeInternal = "internal_error"
+)
+
+// loginError is a failed login with the HTTP status and error code it is reported with.
+// It implements logical.HTTPCodedError.
+type loginError struct {
+	code   string
+	status int
+	err    error
+}
+
+func (e *loginError) Error() string {
+	return e.err.Error()
+}
+
+func (e *loginError) Unwrap() error {
+	return e.err
+}
+
+// Code returns the HTTP status of the error
+func (e *loginError) Code() int {
+	return e.status
+}
+
+func invalidRequestError(err error) error {
+	return &loginError{code: ErrorCodeInvalidRequest, status: http.StatusBadRequest, err: err}
+}
+
+func accessDeniedError(err error) error {
+	return &loginError{code: ErrorCodeAccessDenied, status: http.StatusForbidden, err: err}
+}
+
+func rateLimitedError(err error) error {
+	return &loginError{code: ErrorCodeRateLimited, status: http.StatusTooManyRequests, err: err}
+}
+
+func lockedOutError(err error) error {
+	return &loginError{code: ErrorCodeLockedOut, status: http.StatusForbidden, err: err}
+}
+
+func upstreamUnavailableError(err error) error {
+	return &loginError{code: ErrorCodeUpstreamUnavailable, status: http.StatusServiceUnavailable, err: err}
+}
+
+// isAuthenticationFailure returns whether OCI Identity rejected the signed request,
+// as opposed to being unavailable
+func isAuthenticationFailure(err error) bool {
+	serviceError, ok := common.IsServiceError(err)
+	if !ok {
+		return false
+	}
+	statusCode := serviceError.GetHTTPStatusCode()
+	return statusCode >= 400 && statusCode < 500 && statusCode != http.StatusTooManyRequests
+}
+
+// upstreamError classifies an error of a call to OCI Identity. Rejected signatures are denied, throttling,
+// outages and network errors are unavailable, and any other error is an upstream error.
+func upstreamError(err error) error {
+	if isAuthenticationFailure(err) {
+		return accessDeniedError(err)
+	}
+
+	if serviceError, ok := common.IsServiceError(err); ok {
+		switch serviceError.GetHTTPStatusCode() {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return upstreamUnavailableError(err)
+		}
+		return &loginError{code: ErrorCodeUpstreamError, status: http.StatusBadGateway, err: err}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return upstreamUnavailableError(err)
+	}
+
+	return &loginError{code: ErrorCodeUpstreamError, status: http.StatusBadGateway, err: err}
+}
+
+// loginErrorResponse reports a failed login as a logical.CodedError with the HTTP status of its class, so that
+// Vault, its audit devices and its clients see an error. Vault returns only the message of the error to the
+// caller, so the message ends with the error_code and a correlation ID. Errors without a class are reported as
+// internal errors.
+//
+// The response that accompanies the error keeps the error_code, the correlation ID and the request IDs of the
+// calls to OCI Identity made for the login in opc_request_ids, so that the login can be matched to an OCI support
+// ticket. The cause of the error is always logged and kept in the error_detail of the response, but is only
+// returned to the caller if the error_verbosity of the config is detailed. Audit devices only log error_detail in
+// the clear if the mount lists it in its audit_non_hmac_response_keys.
+func (b *backend) loginErrorResponse(ctx context.Context, req *logical.Request, err error) (*logical.Response, error) {
+	var loginErr *loginError
+	if !errors.As(err, &loginErr) {
+		loginErr = &loginError{code: ErrorCodeInternal, status: http.StatusInternalServerError, err: err}
+	}
+
+	correlationId := req.ID
+	if correlationId == "" {
+		correlationId, _ = uuid.GenerateUUID()
+	}
+
+	recordLoginFailure(ctx, loginErr.code, loginErr.err)
+	b.sendLoginFailureEvent(ctx, req, loginErr.code, loginErr.err)
+
+	opcRequestIds := opcRequestIdsOf(ctx)
+	b.Logger().Info("login failed", "correlation_id", correlationId, "path", req.Path,
+		"error_code", loginErr.code, "opc_request_ids", strings.Join(opcRequestIds, ","), "error", loginErr.err)
+
+	verbosity := ErrorVerbosityDetailed
+	configEntry, configErr := b.getOCIConfig(ctx, req.Storage)
+	if configErr != nil {
+		b.Logger().Warn("unable to read the error verbosity, reporting a generic error", "error", configErr)
+		verbosity = ErrorVerbosityGeneric
+	} else if configEntry != nil {
+		verbosity = configEntry.errorVerbosity()
+	}
+
+	code, status, message := loginErr.code, loginErr.status, loginErr.Error()
+	if verbosity == ErrorVerbosityGeneric {
+		// Invalid requests are reported like denied logins, so that callers can not tell
+		// whether a role exists
+		if code == ErrorCodeInvalidRequest {
+			code, status = ErrorCodeAccessDenied, http.StatusForbidden
+		}
+		message = "login failed"
+	}
+	message = fmt.Sprintf("%s (error_code: %s, correlation_id: %s)", message, code, correlationId)
+
+	resp := logical.ErrorResponse(message)
+	resp.Data["error_code"] = code
+	resp.Data["error_detail"] = loginErr.Error()
+	resp.Data["correlation_id"] = correlationId
+	resp.Data["opc_request_ids"] = opcRequestIds
+	return resp, logical.CodedError(status, message)
+}
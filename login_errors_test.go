@@ -0,0 +1,154 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// testServiceError is a common.ServiceError returned by OCI Identity with the given status
+type testServiceError struct {
+	status int
+}
+
+func (e testServiceError) Error() string           { return fmt.Sprintf("service error %d", e.status) }
+func (e testServiceError) GetHTTPStatusCode() int  { return e.status }
+func (e testServiceError) GetMessage() string      { return "message" }
+func (e testServiceError) GetCode() string         { return "code" }
+func (e testServiceError) GetOpcRequestID() string { return "opc-request-id" }
+
+var _ common.ServiceError = testServiceError{}
+
+func TestUpstreamError(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		errorCode string
+		status    int
+	}{
+		"unauthorized":      {testServiceError{http.StatusUnauthorized}, ErrorCodeAccessDenied, http.StatusForbidden},
+		"not found":         {testServiceError{http.StatusNotFound}, ErrorCodeAccessDenied, http.StatusForbidden},
+		"throttled":         {testServiceError{http.StatusTooManyRequests}, ErrorCodeUpstreamUnavailable, http.StatusServiceUnavailable},
+		"unavailable":       {testServiceError{http.StatusServiceUnavailable}, ErrorCodeUpstreamUnavailable, http.StatusServiceUnavailable},
+		"internal":          {testServiceError{http.StatusInternalServerError}, ErrorCodeUpstreamError, http.StatusBadGateway},
+		"deadline exceeded": {fmt.Errorf("calling identity: %w", context.DeadlineExceeded), ErrorCodeUpstreamUnavailable, http.StatusServiceUnavailable},
+		"other":             {errors.New("unexpected"), ErrorCodeUpstreamError, http.StatusBadGateway},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var loginErr *loginError
+			if !errors.As(upstreamError(tt.err), &loginErr) {
+				t.Fatalf("expected a login error")
+			}
+			if loginErr.code != tt.errorCode || loginErr.Code() != tt.status {
+				t.Fatalf("expected %s (%d), got %s (%d)", tt.errorCode, tt.status, loginErr.code, loginErr.Code())
+			}
+			if !errors.Is(loginErr, tt.err) {
+				t.Fatalf("expected the error to wrap %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestLoginErrorVerbosity(t *testing.T) {
+	login := func(t *testing.T, verbosity string) (*logical.Response, error) {
+		b, storage := setupTestLoginBackend(t, "testrole", map[string]interface{}{"error_verbosity": verbosity})
+		return b.HandleRequest(context.Background(), &logical.Request{
+			ID:        "request-id",
+			Operation: logical.UpdateOperation,
+			Path:      "login/missingrole",
+			Storage:   storage,
+			Data:      map[string]interface{}{},
+		})
+	}
+
+	t.Run("Detailed", func(t *testing.T) {
+		resp, err := login(t, ErrorVerbosityDetailed)
+		status, errorCode, message := loginErrorOf(t, resp, err)
+		if status != http.StatusBadRequest || errorCode != ErrorCodeInvalidRequest ||
+			message != "Role is not found (error_code: invalid_request, correlation_id: request-id)" {
+			t.Fatalf("unexpected error: %d %s %q", status, errorCode, message)
+		}
+		if resp.Data["correlation_id"] != "request-id" {
+			t.Fatalf("expected the request ID as correlation ID, got: %q", resp.Data["correlation_id"])
+		}
+	})
+
+	t.Run("Generic", func(t *testing.T) {
+		resp, err := login(t, ErrorVerbosityGeneric)
+		status, errorCode, message := loginErrorOf(t, resp, err)
+		if status != http.StatusForbidden || errorCode != ErrorCodeAccessDenied ||
+			message != "login failed (error_code: access_denied, correlation_id: request-id)" {
+			t.Fatalf("unexpected error: %d %s %q", status, errorCode, message)
+		}
+		if resp.Data["correlation_id"] != "request-id" {
+			t.Fatalf("expected the request ID as correlation ID, got: %q", resp.Data["correlation_id"])
+		}
+		if detail, _ := resp.Data["error_detail"].(string); !strings.Contains(detail, "Role is not found") {
+			t.Fatalf("expected the cause in the audited error detail, got: %q", detail)
+		}
+	})
+
+	t.Run("GenericCause", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, "testrole", map[string]interface{}{"error_verbosity": ErrorVerbosityGeneric})
+		useFakeIdentity(t, b, &fakeIdentity{
+			subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+			principalType: PrincipalTypeInstance,
+			groupIds:      []string{"ocid9"},
+		})
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			ID:        "request-id",
+			Operation: logical.UpdateOperation,
+			Path:      "login/testrole",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "testrole"), nil),
+			},
+		})
+		_, errorCode, message := loginErrorOf(t, resp, err)
+		if errorCode != ErrorCodeAccessDenied || strings.Contains(message, "Role OCIDs") {
+			t.Fatalf("expected a generic error, got: %s %q", errorCode, message)
+		}
+
+		// These are the keys the README asks operators to exclude from audit HMAC
+		for _, key := range []string{"error_code", "error_detail", "correlation_id", "opc_request_ids"} {
+			if _, ok := resp.Data[key]; !ok {
+				t.Fatalf("expected %s in the response, got: %#v", key, resp.Data)
+			}
+		}
+		if detail, _ := resp.Data["error_detail"].(string); detail != "Entity not a part of any of the Role OCIDs" {
+			t.Fatalf("expected the cause in the audited error detail, got: %q", detail)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		b, err := Backend()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := logical.TestBackendConfig()
+		config.StorageView = &logical.InmemStorage{}
+		if err := b.Setup(context.Background(), config); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				HomeTenancyIdConfigName: "ocid1.tenancy.oc1..aaaatest",
+				"error_verbosity":       "verbose",
+			},
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected the config to be rejected, got resp:%#v err:%v", resp, err)
+		}
+	})
+}
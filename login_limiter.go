@@ -0,0 +1,131 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+)
+
+// These constants bound the memory used to track failed logins, login sources and alias lookaheads
+const (
+	failedLoginCacheSize = 4096
+	failedLoginCacheTTL  = 30 * time.Second
+	loginSourceCacheSize = 4096
+	lookaheadCacheSize   = 4096
+	lookaheadCacheTTL    = 30 * time.Second
+)
+
+// loginLimiter rejects login floods locally, so that they do not exhaust the OCI Identity quota.
+// It remembers recently failed signatures, limits the login rate of each source address, and
+// remembers the principals authenticated by alias lookaheads for the logins that follow them.
+type loginLimiter struct {
+	// Lock to make changes to the limiters of the login sources
+	sourceMutex sync.Mutex
+
+	// Expiration times of recently failed signatures, keyed on the signature hash
+	failedLogins *lru.Cache
+
+	// Token buckets of the login sources, keyed on the remote address
+	sourceLimiters *lru.Cache
+
+	// Principals authenticated by recent alias lookaheads, keyed on the signature hash
+	lookaheads *lru.Cache
+}
+
+// lookaheadEntry is a principal authenticated by an alias lookahead, and when it may no longer be reused
+type lookaheadEntry struct {
+	principal *Principal
+	expires   time.Time
+}
+
+func newLoginLimiter() *loginLimiter {
+	// The sizes are positive constants, so creating the caches can not fail
+	failedLogins, _ := lru.New(failedLoginCacheSize)
+	sourceLimiters, _ := lru.New(loginSourceCacheSize)
+	lookaheads, _ := lru.New(lookaheadCacheSize)
+
+	return &loginLimiter{
+		failedLogins:   failedLogins,
+		sourceLimiters: sourceLimiters,
+		lookaheads:     lookaheads,
+	}
+}
+
+// signatureKey returns the hash of the authorization header, which identifies a signed login request
+func signatureKey(requestHeaders http.Header) string {
+	hash := sha256.Sum256([]byte(requestHeaders.Get(HdrAuthorization)))
+	return hex.EncodeToString(hash[:])
+}
+
+// recordFailure remembers that the login with the given signature failed
+func (l *loginLimiter) recordFailure(key string) {
+	l.failedLogins.Add(key, time.Now().Add(failedLoginCacheTTL))
+}
+
+// recentlyFailed returns whether the login with the given signature failed within the cache window
+func (l *loginLimiter) recentlyFailed(key string) bool {
+	value, ok := l.failedLogins.Get(key)
+	if !ok {
+		return false
+	}
+	if time.Now().After(value.(time.Time)) {
+		l.failedLogins.Remove(key)
+		return false
+	}
+	return true
+}
+
+// recordLookahead remembers the principal an alias lookahead with the given signature authenticated
+func (l *loginLimiter) recordLookahead(key string, principal *Principal) {
+	l.lookaheads.Add(key, lookaheadEntry{principal: principal, expires: time.Now().Add(lookaheadCacheTTL)})
+}
+
+// takeLookahead returns the principal authenticated by a recent alias lookahead with the given signature,
+// or nil if there is none. The principal is only returned once, for the login that follows the lookahead.
+func (l *loginLimiter) takeLookahead(key string) *Principal {
+	value, ok := l.lookaheads.Get(key)
+	if !ok {
+		return nil
+	}
+	l.lookaheads.Remove(key)
+
+	entry := value.(lookaheadEntry)
+	if time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry.principal
+}
+
+// allow returns whether a login from the given source is within the rate limit.
+// A limit of zero disables the rate limiting.
+func (l *loginLimiter) allow(source string, limit float64, burst int) bool {
+	if limit <= 0 || source == "" {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.sourceMutex.Lock()
+	defer l.sourceMutex.Unlock()
+
+	var limiter *rate.Limiter
+	if value, ok := l.sourceLimiters.Get(source); ok {
+		limiter = value.(*rate.Limiter)
+		if limiter.Limit() != rate.Limit(limit) || limiter.Burst() != burst {
+			limiter.SetLimit(rate.Limit(limit))
+			limiter.SetBurst(burst)
+		}
+	} else {
+		limiter = rate.NewLimiter(rate.Limit(limit), burst)
+		l.sourceLimiters.Add(source, limiter)
+	}
+
+	return limiter.Allow()
+}
@@ -0,0 +1,149 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	l := newLoginLimiter()
+
+	key := signatureKey(http.Header{"Authorization": []string{`Signature keyId="k",signature="s"`}})
+	if l.recentlyFailed(key) {
+		t.Fatal("expected no recorded failure")
+	}
+	l.recordFailure(key)
+	if !l.recentlyFailed(key) {
+		t.Fatal("expected the failure to be recorded")
+	}
+
+	// A zero limit disables the rate limiting
+	for i := 0; i < 10; i++ {
+		if !l.allow("192.0.2.1", 0, 1) {
+			t.Fatal("expected an unlimited login to be allowed")
+		}
+	}
+
+	if !l.allow("192.0.2.1", 0.001, 2) || !l.allow("192.0.2.1", 0.001, 2) {
+		t.Fatal("expected logins within the burst to be allowed")
+	}
+	if l.allow("192.0.2.1", 0.001, 2) {
+		t.Fatal("expected a login above the burst to be rejected")
+	}
+	if !l.allow("192.0.2.2", 0.001, 2) {
+		t.Fatal("expected a login from another source to be allowed")
+	}
+}
+
+func TestLogin_RateLimit(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	b, storage := setupTestLoginBackend(t, role, map[string]interface{}{
+		"login_rate_limit":        0.001,
+		"login_rate_burst":        1,
+		"required_signed_headers": "x-vault-oci-test",
+	})
+
+	login := func() (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login/" + role,
+			Storage:    storage,
+			Connection: &logical.Connection{RemoteAddr: "192.0.2.1"},
+			Data: map[string]interface{}{
+				"request_headers": signTestLoginRequest(t, "https://vault.example.com", signingPath, nil),
+			},
+		})
+	}
+
+	// The first login is within the burst and is rejected because the signature misses a required header
+	resp, err := login()
+	if status, _, _ := loginErrorOf(t, resp, err); status != http.StatusBadRequest {
+		t.Fatalf("expected the first login to be rejected by validation, got %d", status)
+	}
+
+	resp, err = login()
+	if status, errorCode, _ := loginErrorOf(t, resp, err); status != http.StatusTooManyRequests || errorCode != ErrorCodeRateLimited {
+		t.Fatalf("expected a too many requests error, got %d %q", status, errorCode)
+	}
+}
+
+func TestLogin_RecentlyFailedSignature(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	b, storage := setupTestLoginBackend(t, role, nil)
+
+	headers := signTestLoginRequest(t, "https://vault.example.com", signingPath, nil)
+	b.loginLimiter.recordFailure(signatureKey(headers))
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/" + role,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"request_headers": headers,
+		},
+	})
+	if status, _, errString := loginErrorOf(t, resp, err); status != http.StatusForbidden || !strings.Contains(errString, "recently failed") {
+		t.Fatalf("unexpected error: %d %q", status, errString)
+	}
+
+	// The replayed signature must be rejected before any client to OCI Identity is created
+	if b.authenticationClient != nil {
+		t.Fatal("expected no authentication client to be created")
+	}
+}
+
+func TestLogin_AliasLookaheadLimits(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	b, storage := setupTestLoginBackend(t, role, map[string]interface{}{
+		"login_rate_limit": 0.001,
+		"login_rate_burst": 1,
+	})
+	identity := &fakeIdentity{
+		subjectId:     "ocid1.user.oc1..bbbbtest",
+		principalType: PrincipalTypeUser,
+		groupIds:      []string{"ocid1"},
+	}
+	useFakeIdentity(t, b, identity)
+
+	request := func(operation logical.Operation, headers http.Header) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  operation,
+			Path:       "login/" + role,
+			Storage:    storage,
+			Connection: &logical.Connection{RemoteAddr: "192.0.2.1"},
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+	}
+
+	// The login that follows a lookahead reuses its principal and is not counted again
+	headers := signTestLoginRequest(t, "https://vault.example.com", signingPath, nil)
+	if resp, err := request(logical.AliasLookaheadOperation, headers); err != nil || resp == nil || resp.Auth == nil {
+		t.Fatalf("Alias lookahead failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp, err := request(logical.UpdateOperation, headers); err != nil || resp == nil || resp.Auth == nil {
+		t.Fatalf("Login failed. resp:%#v\n err:%v", resp, err)
+	}
+	if calls := len(identity.requests["/v1/authentication/authenticateClient"]); calls != 1 {
+		t.Fatalf("expected a single authentication by OCI Identity, got %d", calls)
+	}
+
+	// Further lookaheads from the address are limited like logins
+	resp, err := request(logical.AliasLookaheadOperation, signTestLoginRequest(t, "https://vault.example.com", signingPath, nil))
+	if status, errorCode, _ := loginErrorOf(t, resp, err); status != http.StatusTooManyRequests || errorCode != ErrorCodeRateLimited {
+		t.Fatalf("expected a too many requests error, got %d %q", status, errorCode)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -57,6 +58,12 @@ func (client *OciClient) setConfigurationProvider(configProvider common.Configur
 
 // ConstructLoginRequest takes in a path and returns a signed http request
 func (client OciClient) ConstructLoginRequest(path string) (request http.Request, err error) {
+	return client.ConstructLoginRequestWithHeaders(path, nil)
+}
+
+// ConstructLoginRequestWithHeaders takes in a path and additional headers, and returns an http request
+// whose signature covers the additional headers as well as the default ones
+func (client OciClient) ConstructLoginRequestWithHeaders(path string, headers map[string]string) (request http.Request, err error) {
 	httpRequest, err := common.MakeDefaultHTTPRequestWithTaggedStruct(http.MethodGet, path, request)
 	if err != nil {
 		return
@@ -67,7 +74,18 @@ func (client OciClient) ConstructLoginRequest(path string) (request http.Request
 		return
 	}
 
-	err = client.Signer.Sign(&httpRequest)
+	signer := client.Signer
+	if len(headers) > 0 {
+		signingHeaders := common.DefaultGenericHeaders()
+		for name, value := range headers {
+			httpRequest.Header.Set(name, value)
+			signingHeaders = append(signingHeaders, strings.ToLower(name))
+		}
+		sort.Strings(signingHeaders[len(common.DefaultGenericHeaders()):])
+		signer = common.RequestSigner(*client.config, signingHeaders, common.DefaultBodyHeaders())
+	}
+
+	err = signer.Sign(&httpRequest)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,231 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+// These constants store the operators of an OCID expression
+const (
+	ocidExpressionAnd = "AND"
+	ocidExpressionOr  = "OR"
+	ocidExpressionNot = "NOT"
+)
+
+// ocidExpression is a parsed boolean expression over the group and dynamic group OCIDs of a role,
+// such as (ocid1.group.oc1..devs AND ocid1.group.oc1..oncall) OR ocid1.group.oc1..breakglass
+type ocidExpression interface {
+	// evaluate returns whether the OCIDs the principal is a member of satisfy the expression
+	evaluate(filteredOcidMap map[string]string) bool
+
+	// ocids returns the OCIDs named by the expression
+	ocids() []string
+}
+
+type ocidExpressionRef struct {
+	ocid string
+}
+
+func (e *ocidExpressionRef) evaluate(filteredOcidMap map[string]string) bool {
+	_, present := filteredOcidMap[e.ocid]
+	return present
+}
+
+func (e *ocidExpressionRef) ocids() []string {
+	return []string{e.ocid}
+}
+
+type ocidExpressionNotOp struct {
+	operand ocidExpression
+}
+
+func (e *ocidExpressionNotOp) evaluate(filteredOcidMap map[string]string) bool {
+	return !e.operand.evaluate(filteredOcidMap)
+}
+
+func (e *ocidExpressionNotOp) ocids() []string {
+	return e.operand.ocids()
+}
+
+type ocidExpressionBinaryOp struct {
+	operator string
+	operands []ocidExpression
+}
+
+func (e *ocidExpressionBinaryOp) evaluate(filteredOcidMap map[string]string) bool {
+	for _, operand := range e.operands {
+		if operand.evaluate(filteredOcidMap) == (e.operator == ocidExpressionOr) {
+			return e.operator == ocidExpressionOr
+		}
+	}
+	return e.operator == ocidExpressionAnd
+}
+
+func (e *ocidExpressionBinaryOp) ocids() []string {
+	ocids := []string{}
+	for _, operand := range e.operands {
+		ocids = append(ocids, operand.ocids()...)
+	}
+	return strutil.RemoveDuplicatesStable(ocids, false)
+}
+
+// isOcidExpressionOperator returns whether a name is an operator, which can not be used as an alias
+func isOcidExpressionOperator(name string) bool {
+	return strings.EqualFold(name, ocidExpressionAnd) || strings.EqualFold(name, ocidExpressionOr) || strings.EqualFold(name, ocidExpressionNot)
+}
+
+// ocidExpressionToken is a parenthesis, an operator or a name in an OCID expression, with its position
+type ocidExpressionToken struct {
+	value    string
+	position int
+}
+
+// ocidExpressionParser parses the grammar
+//
+//	expression = term { "OR" term }
+//	term       = factor { "AND" factor }
+//	factor     = "NOT" factor | "(" expression ")" | name
+//
+// where the operators are case insensitive and a name is an OCID or an alias of one
+type ocidExpressionParser struct {
+	tokens  []ocidExpressionToken
+	next    int
+	aliases map[string]string
+	end     int
+}
+
+// parseOcidExpression parses an OCID expression whose names are OCIDs or keys of aliases
+func parseOcidExpression(expression string, aliases map[string]string) (ocidExpression, error) {
+	p := &ocidExpressionParser{
+		tokens:  tokenizeOcidExpression(expression),
+		aliases: aliases,
+		end:     len(expression) + 1,
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("expression is empty")
+	}
+
+	parsed, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q at position %d", token.value, token.position)
+	}
+	return parsed, nil
+}
+
+// tokenizeOcidExpression splits an expression at whitespace and around parentheses.
+// Positions start at 1.
+func tokenizeOcidExpression(expression string) []ocidExpressionToken {
+	tokens := []ocidExpressionToken{}
+	start := -1
+	for i, r := range expression {
+		if unicode.IsSpace(r) || r == '(' || r == ')' {
+			if start >= 0 {
+				tokens = append(tokens, ocidExpressionToken{value: expression[start:i], position: start + 1})
+				start = -1
+			}
+			if r == '(' || r == ')' {
+				tokens = append(tokens, ocidExpressionToken{value: string(r), position: i + 1})
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, ocidExpressionToken{value: expression[start:], position: start + 1})
+	}
+	return tokens
+}
+
+func (p *ocidExpressionParser) peek() (ocidExpressionToken, bool) {
+	if p.next >= len(p.tokens) {
+		return ocidExpressionToken{}, false
+	}
+	return p.tokens[p.next], true
+}
+
+// accept consumes the next token if it is the given parenthesis or operator
+func (p *ocidExpressionParser) accept(value string) bool {
+	if token, ok := p.peek(); ok && strings.EqualFold(token.value, value) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *ocidExpressionParser) parseExpression() (ocidExpression, error) {
+	return p.parseBinary(ocidExpressionOr, p.parseTerm)
+}
+
+func (p *ocidExpressionParser) parseTerm() (ocidExpression, error) {
+	return p.parseBinary(ocidExpressionAnd, p.parseFactor)
+}
+
+// parseBinary parses one or more operands joined by the operator
+func (p *ocidExpressionParser) parseBinary(operator string, parseOperand func() (ocidExpression, error)) (ocidExpression, error) {
+	operand, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []ocidExpression{operand}
+	for p.accept(operator) {
+		if operand, err = parseOperand(); err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return &ocidExpressionBinaryOp{operator: operator, operands: operands}, nil
+}
+
+func (p *ocidExpressionParser) parseFactor() (ocidExpression, error) {
+	token, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression at position %d, expected an OCID, an alias, %s or (", p.end, ocidExpressionNot)
+	}
+
+	switch {
+	case p.accept(ocidExpressionNot):
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &ocidExpressionNotOp{operand: operand}, nil
+
+	case p.accept("("):
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			if next, ok := p.peek(); ok {
+				return nil, fmt.Errorf("expected ) to close the ( at position %d, found %q at position %d", token.position, next.value, next.position)
+			}
+			return nil, fmt.Errorf("expected ) to close the ( at position %d", token.position)
+		}
+		return inner, nil
+
+	case token.value == ")" || isOcidExpressionOperator(token.value):
+		return nil, fmt.Errorf("unexpected %q at position %d, expected an OCID, an alias, %s or (", token.value, token.position, ocidExpressionNot)
+	}
+
+	p.next++
+	if ocid, ok := p.aliases[token.value]; ok {
+		return &ocidExpressionRef{ocid: ocid}, nil
+	}
+	if !strings.HasPrefix(token.value, "ocid") {
+		return nil, fmt.Errorf("unknown alias %q at position %d", token.value, token.position)
+	}
+	return &ocidExpressionRef{ocid: token.value}, nil
+}
@@ -0,0 +1,69 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOcidExpression(t *testing.T) {
+	aliases := map[string]string{
+		"devs":       "ocid1.group.oc1..devs",
+		"oncall":     "ocid1.group.oc1..oncall",
+		"breakglass": "ocid1.group.oc1..breakglass",
+	}
+
+	tests := []struct {
+		expression string
+		memberOf   []string
+		expected   bool
+	}{
+		{"(devs AND oncall) OR breakglass", []string{"ocid1.group.oc1..devs", "ocid1.group.oc1..oncall"}, true},
+		{"(devs AND oncall) OR breakglass", []string{"ocid1.group.oc1..devs"}, false},
+		{"(devs AND oncall) OR breakglass", []string{"ocid1.group.oc1..breakglass"}, true},
+		{"devs and not oncall", []string{"ocid1.group.oc1..devs"}, true},
+		{"devs AND NOT oncall", []string{"ocid1.group.oc1..devs", "ocid1.group.oc1..oncall"}, false},
+		{"devs OR oncall AND breakglass", []string{"ocid1.group.oc1..devs"}, true},
+		{"(devs OR oncall) AND breakglass", []string{"ocid1.group.oc1..devs"}, false},
+		{"NOT NOT ocid1.group.oc1..other", []string{"ocid1.group.oc1..other"}, true},
+		{"((devs))", []string{"ocid1.group.oc1..devs"}, true},
+	}
+	for _, tt := range tests {
+		parsed, err := parseOcidExpression(tt.expression, aliases)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.expression, err)
+		}
+		if actual := parsed.evaluate(sliceToMap(tt.memberOf)); actual != tt.expected {
+			t.Fatalf("%q with %v: expected %v, got %v", tt.expression, tt.memberOf, tt.expected, actual)
+		}
+	}
+
+	parsed, err := parseOcidExpression("(devs AND oncall) OR breakglass OR devs", aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"ocid1.group.oc1..devs", "ocid1.group.oc1..oncall", "ocid1.group.oc1..breakglass"}; !reflect.DeepEqual(parsed.ocids(), expected) {
+		t.Fatalf("unexpected OCIDs: %v", parsed.ocids())
+	}
+
+	errors := []struct {
+		expression string
+		message    string
+	}{
+		{"", "expression is empty"},
+		{"devs AND", "unexpected end of expression at position 9"},
+		{"(devs OR oncall", "expected ) to close the ( at position 1"},
+		{"(devs oncall)", `found "oncall" at position 7`},
+		{"devs OR AND oncall", `unexpected "AND" at position 9`},
+		{"devs oncall", `unexpected "oncall" at position 6`},
+		{"devs)", `unexpected ")" at position 5`},
+		{"devs OR admins", `unknown alias "admins" at position 9`},
+	}
+	for _, tt := range errors {
+		_, err := parseOcidExpression(tt.expression, aliases)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Fatalf("%q: expected an error containing %q, got: %v", tt.expression, tt.message, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// opcRequestIdsKey is the context key of the OCI Identity request IDs of a login
+type opcRequestIdsKey struct{}
+
+// opcRequestIds collects the opc-request-id of each call to OCI Identity made for a login, so that a login
+// can be matched to the Identity requests in an OCI support ticket
+type opcRequestIds struct {
+	mutex sync.Mutex
+	ids   []string
+}
+
+// withOpcRequestIds returns a context that collects the OCI Identity request IDs of the calls made with it
+func withOpcRequestIds(ctx context.Context) context.Context {
+	return context.WithValue(ctx, opcRequestIdsKey{}, &opcRequestIds{})
+}
+
+// recordOpcRequestId records the request ID of a call to OCI Identity, if the context collects them,
+// and returns it for logging
+func recordOpcRequestId(ctx context.Context, id *string) string {
+	if id == nil || *id == "" {
+		return ""
+	}
+
+	if recorder, ok := ctx.Value(opcRequestIdsKey{}).(*opcRequestIds); ok {
+		recorder.mutex.Lock()
+		recorder.ids = append(recorder.ids, *id)
+		recorder.mutex.Unlock()
+	}
+	return *id
+}
+
+// recordOpcRequestIdOfError records the request ID of a call to OCI Identity that returned a service error
+func recordOpcRequestIdOfError(ctx context.Context, err error) {
+	if serviceError, ok := common.IsServiceError(err); ok {
+		recordOpcRequestId(ctx, common.String(serviceError.GetOpcRequestID()))
+	}
+}
+
+// opcRequestIdsOf returns the OCI Identity request IDs collected in the context, in the order of the calls
+func opcRequestIdsOf(ctx context.Context) []string {
+	recorder, ok := ctx.Value(opcRequestIdsKey{}).(*opcRequestIds)
+	if !ok {
+		return []string{}
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]string{}, recorder.ids...)
+}
+
+// opcRequestIdOfResponse returns the request ID of a call to OCI Identity from its response or service error
+func opcRequestIdOfResponse(id *string, err error) string {
+	if serviceError, ok := common.IsServiceError(err); ok {
+		return serviceError.GetOpcRequestID()
+	}
+	if id == nil {
+		return ""
+	}
+	return *id
+}
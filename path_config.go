@@ -3,8 +3,11 @@ package ociauth
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -15,6 +18,18 @@ const (
 	HomeTenancyIdConfigName = "home_tenancy_id"
 )
 
+// These constants store the verbosities of the errors of failed logins
+const (
+	ErrorVerbosityDetailed = "detailed"
+	ErrorVerbosityGeneric  = "generic"
+)
+
+// These constants store what the entity aliases of logins are named after
+const (
+	AliasSourceRole      = "role"
+	AliasSourceSubjectId = "subject_id"
+)
+
 func pathConfig(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "config",
@@ -30,20 +45,20 @@ func pathConfig(b *backend) *framework.Path {
 			},
 			"auth_mode": {
 				Type:        framework.TypeString,
-				Description: "Authentication mode: 'instance' (default) or 'apikey'. Use 'instance' when Vault runs inside OCI, 'apikey' when running outside OCI.",
+				Description: "Authentication mode: 'instance' (default), 'apikey', 'signer', 'resource_principal' or 'oke_workload_identity'. Use 'instance' when Vault runs on an OCI compute instance, 'apikey' when running outside OCI, 'signer' when the private key is held by an external signer, 'resource_principal' when running in an OCI service such as Container Instances, and 'oke_workload_identity' when running as a pod in OKE.",
 				Default:     "instance",
 			},
 			"tenancy_ocid": {
 				Type:        framework.TypeString,
-				Description: "Tenancy OCID for API key authentication (required when auth_mode=apikey).",
+				Description: "Tenancy OCID for API key authentication (required when auth_mode=apikey or auth_mode=signer).",
 			},
 			"user_ocid": {
 				Type:        framework.TypeString,
-				Description: "User OCID for API key authentication (required when auth_mode=apikey).",
+				Description: "User OCID for API key authentication (required when auth_mode=apikey or auth_mode=signer).",
 			},
 			"fingerprint": {
 				Type:        framework.TypeString,
-				Description: "API key fingerprint (required when auth_mode=apikey).",
+				Description: "API key fingerprint (required when auth_mode=apikey or auth_mode=signer).",
 			},
 			"private_key": {
 				Type:        framework.TypeString,
@@ -55,7 +70,94 @@ func pathConfig(b *backend) *framework.Path {
 			},
 			"region": {
 				Type:        framework.TypeString,
-				Description: "OCI region (e.g., us-phoenix-1, required when auth_mode=apikey).",
+				Description: "OCI region (e.g., us-phoenix-1, required when auth_mode=apikey or auth_mode=signer).",
+			},
+			"required_signed_headers": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of headers that login signatures must cover in addition to date, (request-target) and host.",
+			},
+			"allowed_hosts": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of host header values, such as vault.example.com:8200, that login signatures may be made for. If empty, any host is accepted.",
+			},
+			"server_id_header_value": {
+				Type:        framework.TypeString,
+				Description: "If set, login signatures must cover the X-Vault-OCI-Server-ID header and its value must match this value.",
+			},
+			"expected_mount_path": {
+				Type:        framework.TypeString,
+				Description: "Path of this mount below auth/, such as 'oci' or 'team/oci', that login signatures must be made for. Defaults to the mount point reported by Vault.",
+			},
+			"expected_namespace": {
+				Type:        framework.TypeString,
+				Description: "Path of the namespace of this mount, such as 'ns1' or 'ns1/team', that login signatures must be made for. Defaults to the namespace of the mount point reported by Vault.",
+			},
+			"role_priority": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of roles, in the order they are tried for logins that do not specify a role. If empty, the principal must qualify for exactly one role.",
+			},
+			"login_rate_limit": {
+				Type:        framework.TypeFloat,
+				Description: "Maximum sustained rate of login requests per second from a single source address. If 0, the rate is not limited.",
+			},
+			"login_rate_burst": {
+				Type:        framework.TypeInt,
+				Description: "Number of login requests a single source address may make in a burst above login_rate_limit.",
+				Default:     1,
+			},
+			"error_verbosity": {
+				Type:        framework.TypeString,
+				Description: "Errors returned by failed logins: 'detailed' (default) returns the cause, 'generic' returns an opaque message with a correlation ID. The cause is always logged and audited.",
+				Default:     ErrorVerbosityDetailed,
+			},
+			"alias_source": {
+				Type:        framework.TypeString,
+				Description: "What the entity aliases of logins are named after: 'role' (default) names them after the role, so that all principals of a role share an entity, 'subject_id' names them after the OCID of the principal, so that each principal has its own entity, such as for login MFA.",
+				Default:     AliasSourceRole,
+			},
+			"token_revalidation_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Interval at which the group membership of principals with tokens is checked again. Tokens of principals that no longer qualify for their role are denied renewal. If 0, tokens are not renewable and are not re-validated.",
+			},
+			"events_signing_key": {
+				Type:        framework.TypeString,
+				Description: "Shared secret that the signatures of the OCI events posted to events/oci are made with. If empty, OCI events are rejected.",
+			},
+			"events_actions": {
+				Type:        framework.TypeKVPairs,
+				Description: "Map of OCI event types to the action taken for them by events/oci: 'revalidate', 'revoke' or 'ignore'. Overrides the default action of the event type.",
+			},
+			"token_revocation_address": {
+				Type:        framework.TypeString,
+				Description: "Address of the Vault API that the 'revoke' action of events/oci revokes the tracked accessors through, such as https://127.0.0.1:8200. If empty, 'revoke' only denies the renewal of the tokens.",
+			},
+			"token_revocation_token": {
+				Type:        framework.TypeString,
+				Description: "Vault token allowed to update auth/token/revoke-accessor in the namespace of the mount, used with token_revocation_address.",
+			},
+			"token_revocation_ca_cert": {
+				Type:        framework.TypeString,
+				Description: "PEM-encoded CA certificate or bundle that the TLS certificate of token_revocation_address is verified with. If empty, the system CAs are used.",
+			},
+			"token_revocation_tls_server_name": {
+				Type:        framework.TypeString,
+				Description: "Name that the TLS certificate of token_revocation_address is verified for, if it differs from the host of the address.",
+			},
+			"signer_type": {
+				Type:        framework.TypeString,
+				Description: "External signer type: 'kms', 'command' or 'socket' (required when auth_mode=signer).",
+			},
+			"kms_crypto_endpoint": {
+				Type:        framework.TypeString,
+				Description: "OCI KMS crypto endpoint of the vault holding the signing key (required when signer_type=kms).",
+			},
+			"kms_key_id": {
+				Type:        framework.TypeString,
+				Description: "OCID of the asymmetric RSA KMS key matching the API key fingerprint (required when signer_type=kms).",
+			},
+			"kms_key_version_id": {
+				Type:        framework.TypeString,
+				Description: "OCID of the KMS key version to sign with (optional, defaults to the current version).",
 			},
 		},
 
@@ -126,7 +228,7 @@ func (b *backend) setOCIConfig(ctx context.Context, s logical.Storage, configEnt
 // the responsibility of the caller to check if a config upgrade is required and,
 // if so, to upgrade the config
 func (b *backend) getOCIConfig(ctx context.Context, s logical.Storage) (*OCIConfigEntry, error) {
-	entry, err := s.Get(ctx, "config")
+	entry, err := readStorage(ctx, s, "config")
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +257,59 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 		HomeTenancyIdConfigName: configEntry.HomeTenancyId,
 	}
 
+	if len(configEntry.RequiredSignedHeaders) > 0 {
+		responseData["required_signed_headers"] = configEntry.RequiredSignedHeaders
+	}
+
+	if len(configEntry.AllowedHosts) > 0 {
+		responseData["allowed_hosts"] = configEntry.AllowedHosts
+	}
+
+	if configEntry.ServerIdHeaderValue != "" {
+		responseData["server_id_header_value"] = configEntry.ServerIdHeaderValue
+	}
+
+	if configEntry.ExpectedMountPath != "" {
+		responseData["expected_mount_path"] = configEntry.ExpectedMountPath
+	}
+
+	if configEntry.ExpectedNamespace != "" {
+		responseData["expected_namespace"] = configEntry.ExpectedNamespace
+	}
+
+	if len(configEntry.RolePriority) > 0 {
+		responseData["role_priority"] = configEntry.RolePriority
+	}
+
+	if configEntry.LoginRateLimit > 0 {
+		responseData["login_rate_limit"] = configEntry.LoginRateLimit
+		responseData["login_rate_burst"] = configEntry.LoginRateBurst
+	}
+
+	responseData["error_verbosity"] = configEntry.errorVerbosity()
+	responseData["alias_source"] = configEntry.aliasSource()
+
+	if configEntry.TokenRevalidationInterval > 0 {
+		responseData["token_revalidation_interval"] = int64(configEntry.TokenRevalidationInterval.Seconds())
+	}
+
+	if len(configEntry.EventsActions) > 0 {
+		responseData["events_actions"] = configEntry.EventsActions
+	}
+
+	if configEntry.TokenRevocationAddress != "" {
+		responseData["token_revocation_address"] = configEntry.TokenRevocationAddress
+		responseData["token_revocation_status"] = b.tokenRevocationStatus()
+	}
+
+	if configEntry.TokenRevocationCACert != "" {
+		responseData["token_revocation_ca_cert"] = configEntry.TokenRevocationCACert
+	}
+
+	if configEntry.TokenRevocationTLSServerName != "" {
+		responseData["token_revocation_tls_server_name"] = configEntry.TokenRevocationTLSServerName
+	}
+
 	// Add auth_mode if set
 	if configEntry.AuthMode != "" {
 		responseData["auth_mode"] = configEntry.AuthMode
@@ -168,6 +323,21 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 		responseData["region"] = configEntry.Region
 	}
 
+	// Add external signer fields if configured
+	if configEntry.AuthMode == "signer" {
+		responseData["tenancy_ocid"] = configEntry.TenancyOCID
+		responseData["user_ocid"] = configEntry.UserOCID
+		responseData["fingerprint"] = configEntry.Fingerprint
+		responseData["region"] = configEntry.Region
+		responseData["signer_type"] = configEntry.SignerType
+		switch configEntry.SignerType {
+		case SignerTypeKMS:
+			responseData["kms_crypto_endpoint"] = configEntry.KMSCryptoEndpoint
+			responseData["kms_key_id"] = configEntry.KMSKeyId
+			responseData["kms_key_version_id"] = configEntry.KMSKeyVersionId
+		}
+	}
+
 	return &logical.Response{
 		Data: responseData,
 	}, nil
@@ -197,8 +367,10 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, req *logical.Reque
 	}
 
 	// Validate auth_mode
-	if authMode != "instance" && authMode != "apikey" {
-		return logical.ErrorResponse("auth_mode must be 'instance' or 'apikey'"), nil
+	switch authMode {
+	case "instance", "apikey", "signer", "resource_principal", "oke_workload_identity":
+	default:
+		return logical.ErrorResponse("auth_mode must be 'instance', 'apikey', 'signer', 'resource_principal' or 'oke_workload_identity'"), nil
 	}
 
 	configEntry = &OCIConfigEntry{
@@ -206,6 +378,76 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, req *logical.Reque
 		AuthMode:      authMode,
 	}
 
+	for _, header := range data.Get("required_signed_headers").([]string) {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header == "" || header == HdrAuthorization {
+			return logical.ErrorResponse("required_signed_headers contains an invalid header %q", header), nil
+		}
+		configEntry.RequiredSignedHeaders = append(configEntry.RequiredSignedHeaders, header)
+	}
+
+	for _, host := range data.Get("allowed_hosts").([]string) {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			configEntry.AllowedHosts = append(configEntry.AllowedHosts, host)
+		}
+	}
+	configEntry.ServerIdHeaderValue = data.Get("server_id_header_value").(string)
+	configEntry.ExpectedMountPath = normalizeMountPath(data.Get("expected_mount_path").(string))
+	configEntry.ExpectedNamespace = normalizeNamespace(data.Get("expected_namespace").(string))
+
+	for _, roleName := range data.Get("role_priority").([]string) {
+		if roleName = strings.ToLower(strings.TrimSpace(roleName)); roleName != "" {
+			configEntry.RolePriority = append(configEntry.RolePriority, roleName)
+		}
+	}
+
+	configEntry.LoginRateLimit = data.Get("login_rate_limit").(float64)
+	configEntry.LoginRateBurst = data.Get("login_rate_burst").(int)
+	if configEntry.LoginRateLimit < 0 || configEntry.LoginRateBurst < 1 {
+		return logical.ErrorResponse("login_rate_limit must not be negative and login_rate_burst must be at least 1"), nil
+	}
+
+	switch configEntry.ErrorVerbosity = data.Get("error_verbosity").(string); configEntry.ErrorVerbosity {
+	case ErrorVerbosityDetailed, ErrorVerbosityGeneric:
+	default:
+		return logical.ErrorResponse("error_verbosity must be 'detailed' or 'generic'"), nil
+	}
+
+	switch configEntry.AliasSource = data.Get("alias_source").(string); configEntry.AliasSource {
+	case AliasSourceRole, AliasSourceSubjectId:
+	default:
+		return logical.ErrorResponse("alias_source must be 'role' or 'subject_id'"), nil
+	}
+
+	configEntry.TokenRevalidationInterval = time.Duration(data.Get("token_revalidation_interval").(int)) * time.Second
+	if configEntry.TokenRevalidationInterval < 0 {
+		return logical.ErrorResponse("token_revalidation_interval must not be negative"), nil
+	}
+
+	configEntry.EventsSigningKey = data.Get("events_signing_key").(string)
+	for eventType, action := range data.Get("events_actions").(map[string]string) {
+		switch action = strings.ToLower(strings.TrimSpace(action)); action {
+		case OCIEventActionRevalidate, OCIEventActionRevoke, OCIEventActionIgnore:
+		default:
+			return logical.ErrorResponse("events_actions contains an invalid action %q for %q, it must be 'revalidate', 'revoke' or 'ignore'", action, eventType), nil
+		}
+		if configEntry.EventsActions == nil {
+			configEntry.EventsActions = map[string]string{}
+		}
+		configEntry.EventsActions[strings.ToLower(strings.TrimSpace(eventType))] = action
+	}
+
+	configEntry.TokenRevocationAddress = data.Get("token_revocation_address").(string)
+	configEntry.TokenRevocationToken = data.Get("token_revocation_token").(string)
+	if (configEntry.TokenRevocationAddress == "") != (configEntry.TokenRevocationToken == "") {
+		return logical.ErrorResponse("token_revocation_address and token_revocation_token must be set together"), nil
+	}
+	configEntry.TokenRevocationCACert = data.Get("token_revocation_ca_cert").(string)
+	if configEntry.TokenRevocationCACert != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(configEntry.TokenRevocationCACert)) {
+		return logical.ErrorResponse("token_revocation_ca_cert must be a PEM-encoded certificate"), nil
+	}
+	configEntry.TokenRevocationTLSServerName = data.Get("token_revocation_tls_server_name").(string)
+
 	// If API key mode, validate and store credentials
 	if authMode == "apikey" {
 		tenancyOCID := data.Get("tenancy_ocid").(string)
@@ -236,9 +478,52 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, req *logical.Reque
 		configEntry.Region = region
 	}
 
+	// If external signer mode, validate and store the signer details
+	if authMode == "signer" {
+		tenancyOCID := data.Get("tenancy_ocid").(string)
+		userOCID := data.Get("user_ocid").(string)
+		fingerprint := data.Get("fingerprint").(string)
+		region := data.Get("region").(string)
+
+		// Validate required fields
+		if tenancyOCID == "" || userOCID == "" || fingerprint == "" || region == "" {
+			return logical.ErrorResponse(
+				"external signer authentication requires tenancy_ocid, user_ocid, fingerprint, and region",
+			), nil
+		}
+
+		configEntry.TenancyOCID = tenancyOCID
+		configEntry.UserOCID = userOCID
+		configEntry.Fingerprint = fingerprint
+		configEntry.Region = region
+		configEntry.SignerType = data.Get("signer_type").(string)
+
+		switch configEntry.SignerType {
+		case SignerTypeKMS:
+			configEntry.KMSCryptoEndpoint = data.Get("kms_crypto_endpoint").(string)
+			configEntry.KMSKeyId = data.Get("kms_key_id").(string)
+			configEntry.KMSKeyVersionId = data.Get("kms_key_version_id").(string)
+			if configEntry.KMSCryptoEndpoint == "" || configEntry.KMSKeyId == "" {
+				return logical.ErrorResponse("signer_type=kms requires kms_crypto_endpoint and kms_key_id"), nil
+			}
+		case SignerTypeCommand:
+			// The command is taken from the environment of the plugin, never from the config
+			if strings.TrimSpace(os.Getenv(envVarSignerCommand)) == "" {
+				return logical.ErrorResponse("signer_type=command requires the %s environment variable of the plugin", envVarSignerCommand), nil
+			}
+		case SignerTypeSocket:
+			if strings.TrimSpace(os.Getenv(envVarSignerSocket)) == "" {
+				return logical.ErrorResponse("signer_type=socket requires the %s environment variable of the plugin", envVarSignerSocket), nil
+			}
+		default:
+			return logical.ErrorResponse("signer_type must be 'kms', 'command' or 'socket'"), nil
+		}
+	}
+
 	if err := b.setOCIConfig(ctx, req.Storage, configEntry); err != nil {
 		return nil, err
 	}
+	b.sendEvent(ctx, EventTypeConfigWrite, "path", req.Path, "auth_mode", configEntry.AuthMode, "operation", string(req.Operation), "modified", "true")
 
 	b.InvalidateKey(ctx, "config")
 	var resp logical.Response
@@ -260,16 +545,81 @@ func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, da
 type OCIConfigEntry struct {
 	HomeTenancyId string `json:"home_tenancy_id"`
 
-	// Authentication mode: "instance" (default) or "apikey"
+	// Headers that login signatures must cover in addition to date, (request-target) and host
+	RequiredSignedHeaders []string `json:"required_signed_headers,omitempty"`
+
+	// Host header values and server ID that bind login signatures to this Vault server
+	AllowedHosts        []string `json:"allowed_hosts,omitempty"`
+	ServerIdHeaderValue string   `json:"server_id_header_value,omitempty"`
+
+	// Path of this mount below auth/ that login signatures must be made for
+	ExpectedMountPath string `json:"expected_mount_path,omitempty"`
+
+	// Path of the namespace of this mount that login signatures must be made for
+	ExpectedNamespace string `json:"expected_namespace,omitempty"`
+
+	// Roles in the order they are tried for logins that do not specify a role
+	RolePriority []string `json:"role_priority,omitempty"`
+
+	// Per source address limit of the login rate, disabled if 0
+	LoginRateLimit float64 `json:"login_rate_limit,omitempty"`
+	LoginRateBurst int     `json:"login_rate_burst,omitempty"`
+
+	// Verbosity of the errors of failed logins, detailed if empty
+	ErrorVerbosity string `json:"error_verbosity,omitempty"`
+
+	// What the entity aliases of logins are named after, the role if empty
+	AliasSource string `json:"alias_source,omitempty"`
+
+	// Interval at which principals with tokens are re-validated, disabled if 0
+	TokenRevalidationInterval time.Duration `json:"token_revalidation_interval,omitempty"`
+
+	// Shared secret of the signatures of OCI events, which are rejected if empty, and the actions taken per event type
+	EventsSigningKey string            `json:"events_signing_key,omitempty"`
+	EventsActions    map[string]string `json:"events_actions,omitempty"`
+
+	// Vault API and token that tracked accessors are revoked through, revocation only denies renewal if empty
+	TokenRevocationAddress string `json:"token_revocation_address,omitempty"`
+	TokenRevocationToken   string `json:"token_revocation_token,omitempty"`
+
+	// CA certificate and server name that the TLS certificate of the Vault API is verified with, if not the defaults
+	TokenRevocationCACert        string `json:"token_revocation_ca_cert,omitempty"`
+	TokenRevocationTLSServerName string `json:"token_revocation_tls_server_name,omitempty"`
+
+	// Authentication mode: "instance" (default), "apikey", "signer", "resource_principal" or "oke_workload_identity"
 	AuthMode string `json:"auth_mode,omitempty"`
 
-	// API Key fields (used when AuthMode = "apikey")
+	// API Key fields (used when AuthMode = "apikey", all but the private key also when AuthMode = "signer")
 	TenancyOCID          string `json:"tenancy_ocid,omitempty"`
 	UserOCID             string `json:"user_ocid,omitempty"`
 	Fingerprint          string `json:"fingerprint,omitempty"`
 	PrivateKey           string `json:"private_key,omitempty"`
 	PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty"`
 	Region               string `json:"region,omitempty"`
+
+	// External signer fields (used when AuthMode = "signer")
+	SignerType        string `json:"signer_type,omitempty"`
+	KMSCryptoEndpoint string `json:"kms_crypto_endpoint,omitempty"`
+	KMSKeyId          string `json:"kms_key_id,omitempty"`
+	KMSKeyVersionId   string `json:"kms_key_version_id,omitempty"`
+}
+
+// errorVerbosity returns the verbosity of the errors of failed logins, which is detailed for configs
+// written before it could be set
+func (c *OCIConfigEntry) errorVerbosity() string {
+	if c.ErrorVerbosity == "" {
+		return ErrorVerbosityDetailed
+	}
+	return c.ErrorVerbosity
+}
+
+// aliasSource returns what the entity aliases of logins are named after, which is the role for configs
+// written before it could be set
+func (c *OCIConfigEntry) aliasSource() string {
+	if c.AliasSource == "" {
+		return AliasSourceRole
+	}
+	return c.AliasSource
 }
 
 const pathConfigSyn = `
@@ -7,6 +7,7 @@ import (
 
 	"fmt"
 	"os"
+	"reflect"
 
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -259,3 +260,113 @@ MIIEowIBAAKCAQEAtest
 
 	fmt.Println("API key config tests completed successfully")
 }
+
+func TestBackend_PathConfig_Signer(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	baseConfig := func() map[string]interface{} {
+		return map[string]interface{}{
+			HomeTenancyIdConfigName: "ocid1.tenancy.oc1..aaaatest",
+			"auth_mode":             "signer",
+			"tenancy_ocid":          "ocid1.tenancy.oc1..aaaatest",
+			"user_ocid":             "ocid1.user.oc1..bbbbtest",
+			"fingerprint":           "aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99",
+			"region":                "us-phoenix-1",
+		}
+	}
+
+	t.Run("CommandSigner", func(t *testing.T) {
+		configData := baseConfig()
+		configData["signer_type"] = SignerTypeCommand
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data:      configData,
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected the command signer to require %s. resp:%#v\n err:%v", envVarSignerCommand, resp, err)
+		}
+
+		// The command comes only from the environment of the plugin
+		t.Setenv(envVarSignerCommand, "/usr/local/bin/oci-sign --key api")
+		configData["signer_command"] = "/bin/false"
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data:      configData,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Config creation with command signer failed. resp:%#v\n err:%v", resp, err)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Read config failed. resp:%#v\n err:%v", resp, err)
+		}
+		if resp.Data["signer_type"] != SignerTypeCommand || resp.Data["signer_command"] != nil {
+			t.Fatalf("unexpected signer config: %#v", resp.Data)
+		}
+
+		// The client must sign with the external signer rather than the SDK's key based signer
+		authClient, err := b.getOrCreateAuthClient(context.Background(), config.StorageView)
+		if err != nil {
+			t.Fatalf("getOrCreateAuthClient failed: %v", err)
+		}
+		requestSigner, ok := authClient.Signer.(externalRequestSigner)
+		if !ok {
+			t.Fatalf("expected an external request signer, got %T", authClient.Signer)
+		}
+		commandSigner, ok := requestSigner.signer.(*commandSigner)
+		if !ok || !reflect.DeepEqual(commandSigner.args, []string{"/usr/local/bin/oci-sign", "--key", "api"}) {
+			t.Fatalf("expected the command signer of the environment, got %#v", requestSigner.signer)
+		}
+	})
+
+	t.Run("MissingKMSFields", func(t *testing.T) {
+		configData := baseConfig()
+		configData["signer_type"] = SignerTypeKMS
+		configData["kms_crypto_endpoint"] = "https://example-crypto.kms.us-phoenix-1.oraclecloud.com"
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data:      configData,
+		})
+		if err == nil && (resp == nil || !resp.IsError()) {
+			t.Fatalf("Expected error for missing kms_key_id")
+		}
+	})
+
+	t.Run("InvalidSignerType", func(t *testing.T) {
+		configData := baseConfig()
+		configData["signer_type"] = "invalid"
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data:      configData,
+		})
+		if err == nil && (resp == nil || !resp.IsError()) {
+			t.Fatalf("Expected error for invalid signer_type")
+		}
+	})
+}
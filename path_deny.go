@@ -0,0 +1,336 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants store the storage prefixes of the deny lists
+const (
+	denyKeysStoragePrefix       = "deny/keys/"
+	denyPrincipalsStoragePrefix = "deny/principals/"
+)
+
+// fingerprintRegex matches an API key fingerprint such as aa:bb:cc
+const fingerprintRegex = `(?P<fingerprint>[0-9a-fA-F]{2}(:[0-9a-fA-F]{2})*)`
+
+func denyEntryFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"reason": {
+			Type:        framework.TypeString,
+			Description: "Reason for denying the logins.",
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Duration after which the entry expires and logins are allowed again. If not set, the entry does not expire.",
+		},
+	}
+}
+
+func pathDenyKeys(b *backend) *framework.Path {
+	fields := denyEntryFields()
+	fields["fingerprint"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "Fingerprint of the API key to deny.",
+	}
+
+	return &framework.Path{
+		Pattern: "deny/keys/" + fingerprintRegex,
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationSuffix: "denied-key",
+		},
+
+		Fields: fields,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathDenyWrite(denyKeysStoragePrefix, "fingerprint"),
+			logical.ReadOperation:   b.pathDenyRead(denyKeysStoragePrefix, "fingerprint"),
+			logical.DeleteOperation: b.pathDenyDelete(denyKeysStoragePrefix, "fingerprint"),
+		},
+
+		HelpSynopsis:    pathDenyKeysSyn,
+		HelpDescription: pathDenyKeysDesc,
+	}
+}
+
+func pathListDenyKeys(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "deny/keys/?",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "list",
+			OperationSuffix: "denied-keys",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathDenyList(denyKeysStoragePrefix),
+		},
+
+		HelpSynopsis:    pathListDenyKeysSyn,
+		HelpDescription: pathListDenyKeysDesc,
+	}
+}
+
+func pathDenyPrincipals(b *backend) *framework.Path {
+	fields := denyEntryFields()
+	fields["ocid"] = &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "OCID of the user or instance principal to deny.",
+	}
+
+	return &framework.Path{
+		Pattern: "deny/principals/" + framework.GenericNameRegex("ocid"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationSuffix: "denied-principal",
+		},
+
+		Fields: fields,
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathDenyWrite(denyPrincipalsStoragePrefix, "ocid"),
+			logical.ReadOperation:   b.pathDenyRead(denyPrincipalsStoragePrefix, "ocid"),
+			logical.DeleteOperation: b.pathDenyDelete(denyPrincipalsStoragePrefix, "ocid"),
+		},
+
+		HelpSynopsis:    pathDenyPrincipalsSyn,
+		HelpDescription: pathDenyPrincipalsDesc,
+	}
+}
+
+func pathListDenyPrincipals(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "deny/principals/?",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "list",
+			OperationSuffix: "denied-principals",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathDenyList(denyPrincipalsStoragePrefix),
+		},
+
+		HelpSynopsis:    pathListDenyPrincipalsSyn,
+		HelpDescription: pathListDenyPrincipalsDesc,
+	}
+}
+
+// setOCIDenyEntry creates or updates a deny list entry in the storage.
+func (b *backend) setOCIDenyEntry(ctx context.Context, s logical.Storage, prefix, name string, denyEntry *OCIDenyEntry) error {
+	if name == "" {
+		return fmt.Errorf("missing deny entry name")
+	}
+
+	entry, err := logical.StorageEntryJSON(prefix+name, denyEntry)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// getOCIDenyEntry returns the deny list entry with the given name, including expired entries
+func (b *backend) getOCIDenyEntry(ctx context.Context, s logical.Storage, prefix, name string) (*OCIDenyEntry, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing deny entry name")
+	}
+
+	entry, err := s.Get(ctx, prefix+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result OCIDenyEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// isDenied returns whether an unexpired deny list entry with the given name exists
+func (b *backend) isDenied(ctx context.Context, s logical.Storage, prefix, name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+
+	denyEntry, err := b.getOCIDenyEntry(ctx, s, prefix, name)
+	if err != nil {
+		return false, err
+	}
+
+	return denyEntry != nil && !denyEntry.IsExpired(time.Now()), nil
+}
+
+// checkDeniedKey rejects a login signed with a denied API key or by a denied user,
+// judged by the keyId of the signature
+func (b *backend) checkDeniedKey(ctx context.Context, s logical.Storage, requestHeaders http.Header) error {
+	params, err := parseSignatureParams(requestHeaders)
+	if err != nil {
+		return invalidRequestError(err)
+	}
+
+	// API key ids have the form <tenancy>/<user>/<fingerprint>
+	keyIdParts := strings.Split(params["keyid"], "/")
+	if len(keyIdParts) != 3 {
+		return nil
+	}
+
+	denied, err := b.isDenied(ctx, s, denyKeysStoragePrefix, strings.ToLower(keyIdParts[2]))
+	if err != nil {
+		return err
+	}
+	if denied {
+		return accessDeniedError(fmt.Errorf("API key is denied"))
+	}
+
+	return b.checkDeniedPrincipal(ctx, s, keyIdParts[1])
+}
+
+// checkDeniedPrincipal rejects a login by a denied principal
+func (b *backend) checkDeniedPrincipal(ctx context.Context, s logical.Storage, subjectId string) error {
+	denied, err := b.isDenied(ctx, s, denyPrincipalsStoragePrefix, strings.ToLower(subjectId))
+	if err != nil {
+		return err
+	}
+	if denied {
+		return accessDeniedError(fmt.Errorf("principal is denied"))
+	}
+
+	return nil
+}
+
+func (b *backend) pathDenyWrite(prefix, nameField string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		name := strings.ToLower(data.Get(nameField).(string))
+
+		denyEntry := &OCIDenyEntry{
+			Reason: data.Get("reason").(string),
+		}
+		if ttl := data.Get("ttl").(int); ttl > 0 {
+			denyEntry.Expiration = time.Now().Add(time.Duration(ttl) * time.Second).UTC()
+		} else if ttl < 0 {
+			return logical.ErrorResponse("ttl must not be negative"), nil
+		}
+
+		if err := b.setOCIDenyEntry(ctx, req.Storage, prefix, name, denyEntry); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+}
+
+func (b *backend) pathDenyRead(prefix, nameField string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		denyEntry, err := b.getOCIDenyEntry(ctx, req.Storage, prefix, strings.ToLower(data.Get(nameField).(string)))
+		if err != nil {
+			return nil, err
+		}
+		if denyEntry == nil {
+			return nil, nil
+		}
+
+		responseData := map[string]interface{}{
+			"reason":     denyEntry.Reason,
+			"expiration": "",
+			"expired":    denyEntry.IsExpired(time.Now()),
+		}
+		if !denyEntry.Expiration.IsZero() {
+			responseData["expiration"] = denyEntry.Expiration.Format(time.RFC3339)
+		}
+
+		return &logical.Response{
+			Data: responseData,
+		}, nil
+	}
+}
+
+func (b *backend) pathDenyDelete(prefix, nameField string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		return nil, req.Storage.Delete(ctx, prefix+strings.ToLower(data.Get(nameField).(string)))
+	}
+}
+
+func (b *backend) pathDenyList(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		entries, err := req.Storage.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return logical.ListResponse(entries), nil
+	}
+}
+
+// Struct to hold the information associated with a deny list entry
+type OCIDenyEntry struct {
+	Reason string `json:"reason"`
+
+	// Zero if the entry does not expire
+	Expiration time.Time `json:"expiration"`
+}
+
+// IsExpired returns whether the entry has expired at the given time
+func (e *OCIDenyEntry) IsExpired(now time.Time) bool {
+	return !e.Expiration.IsZero() && now.After(e.Expiration)
+}
+
+const pathDenyKeysSyn = `
+Denies logins signed with an API key.
+`
+
+const pathDenyKeysDesc = `
+Logins whose signature keyId names the given API key fingerprint are rejected
+before OCI Identity is called. Use this when an API key has leaked and until
+the key is deleted in OCI.
+
+Example:
+
+vault write auth/oci/deny/keys/aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99 reason="leaked" ttl=72h
+`
+
+const pathListDenyKeysSyn = `
+Lists the denied API key fingerprints.
+`
+
+const pathListDenyKeysDesc = `
+Denied API keys will be listed by their fingerprints, including expired entries.
+`
+
+const pathDenyPrincipalsSyn = `
+Denies logins by a user or instance principal.
+`
+
+const pathDenyPrincipalsDesc = `
+Logins by the principal with the given OCID are rejected. For API key logins the
+user OCID in the signature keyId is checked before OCI Identity is called, for
+all logins the subject of the authenticated principal is checked afterwards.
+
+Example:
+
+vault write auth/oci/deny/principals/ocid1.user.oc1..aaaaaaaexample reason="offboarded"
+`
+
+const pathListDenyPrincipalsSyn = `
+Lists the denied principal OCIDs.
+`
+
+const pathListDenyPrincipalsDesc = `
+Denied principals will be listed by their OCIDs, including expired entries.
+`
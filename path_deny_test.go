@@ -0,0 +1,148 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_PathDeny(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(path string, data map[string]interface{}) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Deny entry write failed. resp:%#v\n err:%v", resp, err)
+		}
+	}
+
+	write("deny/keys/AA:BB:CC:DD", map[string]interface{}{"reason": "leaked"})
+	write("deny/principals/ocid1.user.oc1..bbbbtest", map[string]interface{}{"reason": "offboarded", "ttl": "1h"})
+	write("deny/principals/ocid1.instance.oc1..cccctest", nil)
+
+	// Fingerprints are stored lower cased
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "deny/keys/aa:bb:cc:dd",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Read deny entry failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Data["reason"] != "leaked" || resp.Data["expiration"] != "" {
+		t.Fatalf("unexpected deny entry: %#v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "deny/principals/ocid1.user.oc1..bbbbtest",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Read deny entry failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Data["expiration"] == "" || resp.Data["expired"] != false {
+		t.Fatalf("expected an unexpired deny entry with an expiration: %#v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "deny/principals/",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("List deny entries failed. resp:%#v\n err:%v", resp, err)
+	}
+	if len(resp.Data["keys"].([]string)) != 2 {
+		t.Fatalf("unexpected deny entries: %#v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "deny/principals/ocid1.instance.oc1..cccctest",
+		Storage:   config.StorageView,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Delete deny entry failed. resp:%#v\n err:%v", resp, err)
+	}
+	if denied, _ := b.isDenied(context.Background(), config.StorageView, denyPrincipalsStoragePrefix, "ocid1.instance.oc1..cccctest"); denied {
+		t.Fatal("expected the deleted principal not to be denied")
+	}
+
+	if denied, _ := b.isDenied(context.Background(), config.StorageView, denyPrincipalsStoragePrefix, "ocid1.user.oc1..bbbbtest"); !denied {
+		t.Fatal("expected the principal to be denied")
+	}
+}
+
+func TestOCIDenyEntry_IsExpired(t *testing.T) {
+	now := time.Now()
+
+	if (&OCIDenyEntry{}).IsExpired(now) {
+		t.Fatal("expected an entry without expiration never to expire")
+	}
+	if !(&OCIDenyEntry{Expiration: now.Add(-time.Minute)}).IsExpired(now) {
+		t.Fatal("expected an entry in the past to be expired")
+	}
+	if (&OCIDenyEntry{Expiration: now.Add(time.Minute)}).IsExpired(now) {
+		t.Fatal("expected an entry in the future not to be expired")
+	}
+}
+
+func TestLogin_DeniedKey(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	for name, denyPath := range map[string]string{
+		"Fingerprint": "deny/keys/aa:bb:cc:dd",
+		"User":        "deny/principals/ocid1.user.oc1..bbbbtest",
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, storage := setupTestLoginBackend(t, role, nil)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      denyPath,
+				Storage:   storage,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("Deny entry write failed. resp:%#v\n err:%v", resp, err)
+			}
+
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login/" + role,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"request_headers": signTestLoginRequest(t, "https://vault.example.com", signingPath, nil),
+				},
+			})
+			if status, _, errString := loginErrorOf(t, resp, err); status != http.StatusForbidden || !strings.Contains(errString, "denied") {
+				t.Fatalf("unexpected error: %d %q", status, errString)
+			}
+			if b.authenticationClient != nil {
+				t.Fatal("expected no authentication client to be created")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault-plugin-auth-oci/version"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+func pathInfo(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "info$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "read",
+			OperationSuffix: "info",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathInfoRead,
+		},
+
+		HelpSynopsis:    pathInfoSyn,
+		HelpDescription: pathInfoDesc,
+	}
+}
+
+func (b *backend) pathInfoRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.authClientMutex.RLock()
+	authMode := b.authClientMode
+	b.authClientMutex.RUnlock()
+
+	// Until the first login creates the client, report the auth_mode it will be created for
+	if authMode == "" {
+		config, err := b.getOCIConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		authMode = configuredAuthMode(config)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":         version.Version,
+			"git_commit":      version.GitCommit,
+			"oci_sdk_version": common.Version(),
+			"auth_mode":       authMode,
+		},
+	}, nil
+}
+
+const pathInfoSyn = `
+Reports the version of the plugin and the auth_mode of its OCI Identity client.
+`
+
+const pathInfoDesc = `
+Reports the version and commit the plugin was built from, the version of the
+OCI Go SDK it was built with, and the auth_mode of the client the plugin uses
+to call OCI Identity. Until the client is created by the first login after the
+config was written, the auth_mode is the one of the config, or instance if it
+is not set.
+`
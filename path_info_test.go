@@ -0,0 +1,60 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-auth-oci/version"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+func TestBackend_PathInfo(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.PluginVersion().Version != version.Version {
+		t.Fatalf("expected the running version %q, got %q", version.Version, b.PluginVersion().Version)
+	}
+
+	readInfo := func() map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "info",
+			Storage:   config.StorageView,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("Info read failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp.Data
+	}
+
+	info := readInfo()
+	if info["version"] != version.Version || info["oci_sdk_version"] != common.Version() {
+		t.Fatalf("unexpected info: %#v", info)
+	}
+	if info["auth_mode"] != "instance" {
+		t.Fatalf("expected the default auth_mode without a config, got: %v", info["auth_mode"])
+	}
+
+	writeTestConfig(t, b, config.StorageView, "resource_principal")
+	if info := readInfo(); info["auth_mode"] != "resource_principal" {
+		t.Fatalf("expected the auth_mode of the config before the client is created, got: %v", info["auth_mode"])
+	}
+	fakeResourcePrincipalEnvironment(t)
+	if _, err := b.getOrCreateAuthClient(context.Background(), config.StorageView); err != nil {
+		t.Fatalf("getOrCreateAuthClient failed: %v", err)
+	}
+	if info := readInfo(); info["auth_mode"] != "resource_principal" {
+		t.Fatalf("expected the auth_mode of the client, got: %v", info["auth_mode"])
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+func pathIntrospect(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "introspect$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "introspect",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"request_headers": {
+				Type:        framework.TypeHeader,
+				Description: `The signed headers of the client`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathIntrospectUpdate,
+		},
+
+		HelpSynopsis:    pathIntrospectSyn,
+		HelpDescription: pathIntrospectDesc,
+	}
+}
+
+func (b *backend) pathIntrospectUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = withOpcRequestIds(ctx)
+
+	requestHeaders, ok := data.GetOk("request_headers")
+	if !ok {
+		return b.loginErrorResponse(ctx, req, invalidRequestError(fmt.Errorf("request_headers is not specified")))
+	}
+	authenticateRequestHeaders := requestHeaders.(http.Header)
+
+	if err := validateHeaderLimits(authenticateRequestHeaders); err != nil {
+		return b.loginErrorResponse(ctx, req, invalidRequestError(err))
+	}
+
+	requestMetadata := common.RequestMetadata{
+		RetryPolicy: nil,
+	}
+
+	// Introspection neither consults nor feeds the failed login cache and rate limits of logins, so that an
+	// operator checking a signature can not lock out the logins made with it
+	authClient, principal, err := b.authenticatePrincipal(ctx, req, authenticateRequestHeaders, "", requestMetadata)
+	if err != nil {
+		return b.loginErrorResponse(ctx, req, err)
+	}
+
+	// Check the membership of the principal in the OCIDs of all roles at once
+	roleNames, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+	roleEntries := make(map[string]*OCIRoleEntry, len(roleNames))
+	ocids := []string{}
+	for _, name := range roleNames {
+		roleEntry, err := b.getOCIRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			continue
+		}
+		roleEntries[name] = roleEntry
+		ocids = append(ocids, roleEntry.membershipOcids()...)
+	}
+
+	filteredOcidMap := map[string]string{}
+	if len(ocids) > 0 {
+		filteredOcidMap, err = b.filterGroupMembership(ctx, req, authClient, *principal, strutil.RemoveDuplicates(ocids, false), requestMetadata)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
+	}
+
+	groups := make([]string, 0, len(filteredOcidMap))
+	for ocid := range filteredOcidMap {
+		groups = append(groups, ocid)
+	}
+	sort.Strings(groups)
+
+	internalClaims := FromClaims(principal.Claims)
+	principalType := internalClaims.GetString(ClaimPrincipalType)
+	credentialType := credentialTypeOf(authenticateRequestHeaders, internalClaims)
+
+	qualifyingRoles := []string{}
+	for _, name := range roleNames {
+		if roleEntry, ok := roleEntries[name]; ok && roleEntry.qualifies(filteredOcidMap) && roleEntry.admits(principalType, credentialType) == nil {
+			qualifyingRoles = append(qualifyingRoles, name)
+		}
+	}
+
+	claims := make([]map[string]interface{}, 0, len(principal.Claims))
+	for _, claim := range principal.Claims {
+		internalClaim := FromClaim(claim)
+		claims = append(claims, map[string]interface{}{
+			"key":    internalClaim.Key,
+			"value":  internalClaim.Value,
+			"issuer": internalClaim.Issuer,
+		})
+	}
+
+	tenantId, subjectId := "", ""
+	if principal.TenantId != nil {
+		tenantId = *principal.TenantId
+	}
+	if principal.SubjectId != nil {
+		subjectId = *principal.SubjectId
+	}
+	homeTenancyErr := b.validateHomeTenancy(ctx, req, tenantId)
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"tenant_id":        tenantId,
+			"subject_id":       subjectId,
+			"principal_type":   principalType,
+			"credential_type":  credentialType,
+			"claims":           claims,
+			"in_home_tenancy":  homeTenancyErr == nil,
+			"groups":           groups,
+			"qualifying_roles": qualifyingRoles,
+			"opc_request_ids":  opcRequestIdsOf(ctx),
+		},
+	}
+
+	// Report whether the tokens of the principal can be revoked when it no longer qualifies for its roles
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if configEntry != nil && configEntry.TokenRevocationAddress != "" {
+		resp.Data["token_revocation_status"] = b.tokenRevocationStatus()
+	}
+
+	return resp, nil
+}
+
+const pathIntrospectSyn = `
+Reports what OCI Identity returns for a signed login request, without issuing a token.
+`
+
+const pathIntrospectDesc = `
+Takes the same signed request_headers as a login, authenticates them with OCI
+Identity and reports the tenant, subject, claims and credential type of the
+principal, the groups and dynamic groups of all roles it is a member of, and
+the roles it qualifies for. If token_revocation_address is set on the config,
+the outcome of the last revocations through it is reported in
+token_revocation_status. Use this to troubleshoot logins rejected with "Entity not a part
+of any of the Role OCIDs". No token is issued, and the endpoint is protected by
+the ACL policies of the caller like any other authenticated endpoint. It is not
+subject to the login rate limits, and its failures are not cached as failed
+logins.
+`
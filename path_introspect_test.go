@@ -0,0 +1,87 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_PathIntrospect(t *testing.T) {
+	b, storage := setupTestLoginBackend(t, "devrole", map[string]interface{}{
+		"token_revocation_address": "https://127.0.0.1:8200",
+		"token_revocation_token":   "revocation-token",
+	})
+	if err := createRole(map[string]interface{}{"ocid_list": "ocid3"}, "opsrole", b, &logical.BackendConfig{StorageView: storage}); err != nil {
+		t.Fatal(err)
+	}
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     "ocid1.user.oc1..bbbbtest",
+		principalType: PrincipalTypeUser,
+		groupIds:      []string{"ocid2", "ocid9"},
+	})
+
+	headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "opsrole"), nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "introspect",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"request_headers": headers,
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Introspect failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Auth != nil {
+		t.Fatal("expected no token to be issued")
+	}
+
+	if resp.Data["subject_id"] != "ocid1.user.oc1..bbbbtest" || resp.Data["principal_type"] != PrincipalTypeUser ||
+		resp.Data["in_home_tenancy"] != true {
+		t.Fatalf("unexpected principal: %#v", resp.Data)
+	}
+	if !reflect.DeepEqual(resp.Data["groups"], []string{"ocid2"}) {
+		t.Fatalf("unexpected groups: %#v", resp.Data["groups"])
+	}
+	if !reflect.DeepEqual(resp.Data["qualifying_roles"], []string{"devrole"}) {
+		t.Fatalf("unexpected qualifying roles: %#v", resp.Data["qualifying_roles"])
+	}
+	if claims := resp.Data["claims"].([]map[string]interface{}); len(claims) != 1 || claims[0]["key"] != ClaimPrincipalType {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+	if status, ok := resp.Data["token_revocation_status"].(map[string]interface{}); !ok || status["last_success"] != "" || status["last_error"] != "" {
+		t.Fatalf("expected no revocation to be reported yet, got: %#v", resp.Data["token_revocation_status"])
+	}
+}
+
+func TestBackend_PathIntrospectFailure(t *testing.T) {
+	b, storage := setupTestLoginBackend(t, "devrole", nil)
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     "ocid1.user.oc1..bbbbtest",
+		principalType: "robot",
+		groupIds:      []string{"ocid1"},
+	})
+
+	headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "devrole"), nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "introspect",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"request_headers": headers,
+		},
+	})
+	if status, _, _ := loginErrorOf(t, resp, err); status != http.StatusForbidden {
+		t.Fatalf("expected introspection of a wrong principal type to be denied, got %d", status)
+	}
+
+	// A failed introspection must not make the logins with the same signature fail
+	if b.loginLimiter.recentlyFailed(signatureKey(headers)) {
+		t.Fatal("expected the failed introspection not to be cached as a failed login")
+	}
+}
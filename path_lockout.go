@@ -0,0 +1,288 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants store the storage prefix and window of the per principal login state
+const (
+	loginStateStoragePrefix = "lockout/"
+	loginRateWindow         = time.Minute
+)
+
+func pathLockout(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "lockout/" + framework.GenericNameRegex("role") + "/" + framework.GenericNameRegex("subject_id"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationSuffix: "lockout",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role.",
+			},
+			"subject_id": {
+				Type:        framework.TypeString,
+				Description: "OCID of the user or instance principal.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathLockoutRead,
+			logical.DeleteOperation: b.pathLockoutDelete,
+		},
+
+		HelpSynopsis:    pathLockoutSyn,
+		HelpDescription: pathLockoutDesc,
+	}
+}
+
+func pathListLockouts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "lockout/" + framework.GenericNameRegex("role") + "/?",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "list",
+			OperationSuffix: "lockouts",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathLockoutList,
+		},
+
+		HelpSynopsis:    pathListLockoutsSyn,
+		HelpDescription: pathListLockoutsDesc,
+	}
+}
+
+func loginStateKey(roleName, subjectId string) string {
+	return loginStateStoragePrefix + roleName + "/" + strings.ToLower(subjectId)
+}
+
+// getOCILoginState returns the login state of a principal for a role
+func (b *backend) getOCILoginState(ctx context.Context, s logical.Storage, roleName, subjectId string) (*OCILoginStateEntry, error) {
+	entry, err := s.Get(ctx, loginStateKey(roleName, subjectId))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result OCILoginStateEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// setOCILoginState creates or updates the login state of a principal for a role
+func (b *backend) setOCILoginState(ctx context.Context, s logical.Storage, roleName, subjectId string, state *OCILoginStateEntry) error {
+	entry, err := logical.StorageEntryJSON(loginStateKey(roleName, subjectId), state)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// admitLogin enforces the login rate limit and lockout of the role for an authenticated principal.
+// Logins above the rate limit count as failures towards the lockout threshold.
+func (b *backend) admitLogin(ctx context.Context, s logical.Storage, roleName string, roleEntry *OCIRoleEntry, subjectId string) error {
+	if !roleEntry.tracksLogins() || subjectId == "" {
+		return nil
+	}
+
+	b.loginStateMutex.Lock()
+	defer b.loginStateMutex.Unlock()
+
+	state, err := b.getOCILoginState(ctx, s, roleName, subjectId)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &OCILoginStateEntry{}
+	}
+
+	now := time.Now()
+	if state.IsLockedOut(now) {
+		return lockedOutError(fmt.Errorf("principal is locked out of role %q until %s", roleName, state.LockedUntil.Format(time.RFC3339)))
+	}
+
+	if roleEntry.LoginRateLimit > 0 {
+		if now.Sub(state.WindowStart) >= loginRateWindow {
+			state.WindowStart = now.UTC()
+			state.WindowLogins = 0
+		}
+		if state.WindowLogins >= roleEntry.LoginRateLimit {
+			state.recordFailure(now, roleEntry)
+			if err := b.setOCILoginState(ctx, s, roleName, subjectId, state); err != nil {
+				return err
+			}
+			return rateLimitedError(fmt.Errorf("principal exceeded the login rate limit of role %q of %d logins per minute", roleName, roleEntry.LoginRateLimit))
+		}
+		state.WindowLogins++
+	}
+
+	return b.setOCILoginState(ctx, s, roleName, subjectId, state)
+}
+
+// checkLockout rejects an authenticated principal that is locked out of the role, without counting a login.
+// Alias lookaheads are checked with it, since the login that follows them is counted by admitLogin.
+func (b *backend) checkLockout(ctx context.Context, s logical.Storage, roleName string, roleEntry *OCIRoleEntry, subjectId string) error {
+	if !roleEntry.tracksLogins() || subjectId == "" {
+		return nil
+	}
+
+	b.loginStateMutex.Lock()
+	defer b.loginStateMutex.Unlock()
+
+	state, err := b.getOCILoginState(ctx, s, roleName, subjectId)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.IsLockedOut(time.Now()) {
+		return lockedOutError(fmt.Errorf("principal is locked out of role %q until %s", roleName, state.LockedUntil.Format(time.RFC3339)))
+	}
+
+	return nil
+}
+
+// recordLoginResult updates the consecutive failures of an authenticated principal after its login
+// for the role succeeded or failed, and locks it out once the lockout threshold is reached
+func (b *backend) recordLoginResult(ctx context.Context, s logical.Storage, roleName string, roleEntry *OCIRoleEntry, subjectId string, success bool) error {
+	if roleEntry.LockoutThreshold <= 0 || subjectId == "" {
+		return nil
+	}
+
+	b.loginStateMutex.Lock()
+	defer b.loginStateMutex.Unlock()
+
+	state, err := b.getOCILoginState(ctx, s, roleName, subjectId)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &OCILoginStateEntry{}
+	}
+
+	if success {
+		if state.FailedLogins == 0 {
+			return nil
+		}
+		state.FailedLogins = 0
+	} else {
+		state.recordFailure(time.Now(), roleEntry)
+	}
+
+	return b.setOCILoginState(ctx, s, roleName, subjectId, state)
+}
+
+func (b *backend) pathLockoutRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	state, err := b.getOCILoginState(ctx, req.Storage, data.Get("role").(string), data.Get("subject_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, nil
+	}
+
+	responseData := map[string]interface{}{
+		"failed_logins": state.FailedLogins,
+		"window_logins": state.WindowLogins,
+		"locked_out":    state.IsLockedOut(time.Now()),
+		"locked_until":  "",
+	}
+	if !state.LockedUntil.IsZero() {
+		responseData["locked_until"] = state.LockedUntil.Format(time.RFC3339)
+	}
+
+	return &logical.Response{
+		Data: responseData,
+	}, nil
+}
+
+func (b *backend) pathLockoutDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.loginStateMutex.Lock()
+	defer b.loginStateMutex.Unlock()
+
+	return nil, req.Storage.Delete(ctx, loginStateKey(data.Get("role").(string), data.Get("subject_id").(string)))
+}
+
+func (b *backend) pathLockoutList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, loginStateStoragePrefix+data.Get("role").(string)+"/")
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(entries), nil
+}
+
+// Struct to hold the login state of a principal for a role
+type OCILoginStateEntry struct {
+	// Start and number of logins of the current rate limit window
+	WindowStart  time.Time `json:"window_start"`
+	WindowLogins int       `json:"window_logins"`
+
+	// Number of consecutive failed logins
+	FailedLogins int `json:"failed_logins"`
+
+	// Zero if the principal has never been locked out
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// IsLockedOut returns whether the principal is locked out at the given time
+func (e *OCILoginStateEntry) IsLockedOut(now time.Time) bool {
+	return !e.LockedUntil.IsZero() && now.Before(e.LockedUntil)
+}
+
+// recordFailure counts a failed login, and locks the principal out once the threshold of the role is reached
+func (e *OCILoginStateEntry) recordFailure(now time.Time, roleEntry *OCIRoleEntry) {
+	e.FailedLogins++
+	if roleEntry.LockoutThreshold > 0 && e.FailedLogins >= roleEntry.LockoutThreshold {
+		e.LockedUntil = now.Add(roleEntry.LockoutDuration).UTC()
+		e.FailedLogins = 0
+	}
+}
+
+const pathLockoutSyn = `
+Reads or clears the login state of a principal for a role.
+`
+
+const pathLockoutDesc = `
+Roles with a login_rate_limit or lockout_threshold track the logins of each
+principal, keyed on the principal's subject OCID. Reading returns the number of
+consecutive failed logins, the logins in the current rate limit window and
+whether the principal is locked out. Deleting clears the lockout.
+
+Example:
+
+vault delete auth/oci/lockout/devrole/ocid1.instance.oc1.phx.aaaaaaaexample
+`
+
+const pathListLockoutsSyn = `
+Lists the principals with a login state for a role.
+`
+
+const pathListLockoutsDesc = `
+Principals will be listed by their subject OCIDs, including principals that are
+not locked out.
+`
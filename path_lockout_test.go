@@ -0,0 +1,206 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_LoginRateLimitAndLockout(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	storage := config.StorageView
+	subjectId := "ocid1.instance.oc1.phx.aaaatest"
+	roleEntry := &OCIRoleEntry{
+		LoginRateLimit:   2,
+		LockoutThreshold: 2,
+		LockoutDuration:  time.Hour,
+	}
+
+	assertCode := func(err error, code int) {
+		t.Helper()
+		codedErr, ok := err.(logical.HTTPCodedError)
+		if !ok || codedErr.Code() != code {
+			t.Fatalf("expected an error with status %d, got: %v", code, err)
+		}
+		if _, ok := err.(*loginError); !ok {
+			t.Fatalf("expected an error with status %d, got: %v", code, err)
+		}
+	}
+
+	// Logins within the rate limit are admitted
+	for i := 0; i < 2; i++ {
+		if err := b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId); err != nil {
+			t.Fatalf("expected login %d to be admitted: %v", i, err)
+		}
+		if err := b.recordLoginResult(ctx, storage, "devrole", roleEntry, subjectId, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Logins above the rate limit are throttled, and count towards the lockout
+	assertCode(b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId), http.StatusTooManyRequests)
+	assertCode(b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId), http.StatusTooManyRequests)
+	assertCode(b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId), http.StatusForbidden)
+
+	// Other roles are not affected
+	if err := b.admitLogin(ctx, storage, "otherrole", roleEntry, subjectId); err != nil {
+		t.Fatalf("expected a login for another role to be admitted: %v", err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "lockout/devrole/",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("List lockouts failed. resp:%#v\n err:%v", resp, err)
+	}
+	if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != subjectId {
+		t.Fatalf("unexpected lockouts: %#v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "lockout/devrole/" + subjectId,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Read lockout failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Data["locked_out"] != true || resp.Data["locked_until"] == "" {
+		t.Fatalf("expected the principal to be locked out: %#v", resp.Data)
+	}
+
+	// Clearing the lockout admits the principal again
+	if _, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "lockout/devrole/" + subjectId,
+		Storage:   storage,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId); err != nil {
+		t.Fatalf("expected a login to be admitted after clearing the lockout: %v", err)
+	}
+
+	// Failed logins lock the principal out, and a successful login resets the count
+	roleEntry.LoginRateLimit = 0
+	for _, success := range []bool{false, true, false} {
+		if err := b.recordLoginResult(ctx, storage, "devrole", roleEntry, subjectId, success); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId); err != nil {
+		t.Fatalf("expected a login to be admitted below the threshold: %v", err)
+	}
+	if err := b.recordLoginResult(ctx, storage, "devrole", roleEntry, subjectId, false); err != nil {
+		t.Fatal(err)
+	}
+	assertCode(b.admitLogin(ctx, storage, "devrole", roleEntry, subjectId), http.StatusForbidden)
+}
+
+func TestLogin_AliasLookaheadLockout(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	b, storage := setupTestLoginBackend(t, role, nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/" + role,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"lockout_threshold": 2,
+			"lockout_duration":  "1h",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Role update failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	// The principal is not a member of the OCIDs of the role
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     "ocid1.user.oc1..bbbbtest",
+		principalType: PrincipalTypeUser,
+		groupIds:      []string{"ocid9"},
+	})
+
+	lookahead := func() (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.AliasLookaheadOperation,
+			Path:      "login/" + role,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": signTestLoginRequest(t, "https://vault.example.com", signingPath, nil),
+			},
+		})
+	}
+
+	// Failed lookaheads count towards the lockout like failed logins
+	for i := 0; i < 2; i++ {
+		resp, err := lookahead()
+		if status, errorCode, _ := loginErrorOf(t, resp, err); status != http.StatusForbidden || errorCode != ErrorCodeAccessDenied {
+			t.Fatalf("expected lookahead %d to be denied, got %d %q", i, status, errorCode)
+		}
+	}
+	resp, err = lookahead()
+	if status, errorCode, _ := loginErrorOf(t, resp, err); status != http.StatusForbidden || errorCode != ErrorCodeLockedOut {
+		t.Fatalf("expected the principal to be locked out, got %d %q", status, errorCode)
+	}
+}
+
+func TestBackend_PathRoleLockoutFields(t *testing.T) {
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createRole(map[string]interface{}{
+		"ocid_list":         "ocid1",
+		"login_rate_limit":  10,
+		"lockout_threshold": 5,
+	}, "devrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	roleEntry, err := b.getOCIRole(context.Background(), config.StorageView, "devrole")
+	if err != nil || roleEntry == nil {
+		t.Fatalf("failed to read role: %v", err)
+	}
+	if roleEntry.LoginRateLimit != 10 || roleEntry.LockoutThreshold != 5 || roleEntry.LockoutDuration != 5*time.Minute {
+		t.Fatalf("unexpected role entry: %#v", roleEntry)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/devrole",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			"lockout_duration": 0,
+		},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected a zero lockout_duration to be rejected, got resp:%#v err:%v", resp, err)
+	}
+}
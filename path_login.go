@@ -8,8 +8,9 @@ import (
 	"strings"
 	"unicode"
 
-	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/policyutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/pkg/errors"
@@ -19,6 +20,7 @@ import (
 const (
 	PathVersionBase    = "/v1"
 	PathBaseFormat     = "/auth/%s/login/%s"
+	PathLoginFormat    = "/auth/%s/login"
 	PathLoginMethod    = "get"
 	PathSegmentAuth    = "auth"
 	PathSegmentLogin   = "login"
@@ -51,8 +53,9 @@ func pathLoginRole(b *backend) *framework.Path {
 			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation:      b.pathLoginUpdate,
-			logical.ResolveRoleOperation: b.pathResolveRole,
+			logical.UpdateOperation:         b.pathLoginUpdate,
+			logical.AliasLookaheadOperation: b.pathLoginUpdate,
+			logical.ResolveRoleOperation:    b.pathResolveRole,
 		},
 
 		HelpSynopsis:    pathLoginRoleSyn,
@@ -72,8 +75,18 @@ func pathLogin(b *backend) *framework.Path {
 				Type:        framework.TypeLowerCaseString,
 				Description: "Name of the role.",
 			},
+			"all_roles": {
+				Type:        framework.TypeBool,
+				Description: "If set and no role is specified, the token is issued for all roles the principal qualifies for, with the union of their policies and the shortest of their TTLs.",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathLoginUpdate,
+			},
+			logical.AliasLookaheadOperation: &framework.PathOperation{
+				Callback: b.pathLoginUpdate,
+			},
 			logical.ResolveRoleOperation: &framework.PathOperation{
 				Callback: b.pathResolveRole,
 			},
@@ -103,150 +116,335 @@ func (b *backend) pathResolveRole(ctx context.Context, req *logical.Request, dat
 	return logical.ResolveRoleResponse(roleName)
 }
 
+// pathLoginUpdate authenticates a login and issues a token. For an alias lookahead, which Vault performs
+// to enforce login MFA, it authenticates the login the same way but only returns the alias of the token.
 func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// The login that follows a successful lookahead reuses the principal it authenticated, and is counted
+	// against the limits of each principal. A failed lookahead counts towards the lockout like a failed login.
+	lookahead := req.Operation == logical.AliasLookaheadOperation
 
-	// Validate the role
-	role, ok := data.GetOk("role")
-	if !ok {
-		return logical.ErrorResponse("Role is not specified"), nil
+	// Collect the request IDs of the calls to OCI Identity for the response and the logs
+	ctx = withOpcRequestIds(ctx)
+
+	ctx, span := startSpan(ctx, "oci.login", attributeRequestId.String(req.ID))
+	defer span.End()
+
+	// Report the outcome of logins, but not of alias lookaheads, in Vault events
+	event := &loginEvent{}
+	if !lookahead {
+		ctx = withLoginEvent(ctx, event)
 	}
-	roleName := role.(string)
 
-	b.Logger().Trace(req.ID, "pathLoginUpdate roleName", roleName)
+	// Validate the role. Without a role, it is chosen from the group membership of the principal.
+	roleName := ""
+	var roleEntry *OCIRoleEntry
+	if role, ok := data.GetOk("role"); ok {
+		roleName = role.(string)
 
-	// Validate that the role exists
-	roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
-	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		b.Logger().Trace(req.ID, "pathLoginUpdate roleName", roleName)
+		span.SetAttributes(attributeRoleName.String(roleName))
+		event.roleName = roleName
+
+		// Validate that the role exists
+		var err error
+		roleEntry, err = b.getOCIRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
+
+		if roleEntry == nil {
+			return b.loginErrorResponse(ctx, req, invalidRequestError(fmt.Errorf("Role is not found")))
+		}
 	}
 
-	if roleEntry == nil {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("Role is not found")), nil
+	// Logins to login/<role> are signed for the role, logins to login are signed without one
+	signedRoleName := ""
+	if strings.HasPrefix(req.Path, PathSegmentLogin+"/") {
+		signedRoleName = roleName
 	}
 
 	// Parse the authentication headers
-	requestHeaders := data.Get("request_headers")
+	requestHeaders, ok := data.GetOk("request_headers")
 	if !ok {
-		return logical.ErrorResponse("request_headers is not specified"), nil
+		return b.loginErrorResponse(ctx, req, invalidRequestError(fmt.Errorf("request_headers is not specified")))
 	}
 	authenticateRequestHeaders := requestHeaders.(http.Header)
 
-	// Find the targetUrl and Method
-	method, targetUrl, err := requestTargetToMethodURL(authenticateRequestHeaders[HdrRequestTarget], roleName)
+	// Validate the headers before making any call to OCI Identity
+	if err := validateHeaderLimits(authenticateRequestHeaders); err != nil {
+		return b.loginErrorResponse(ctx, req, invalidRequestError(err))
+	}
+
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return b.loginErrorResponse(ctx, req, err)
 	}
-	b.Logger().Trace(req.ID, "Method:", method, "targetUrl:", targetUrl)
 
-	authenticateClientDetails := AuthenticateClientDetails{
-		RequestHeaders: authenticateRequestHeaders,
+	// Limit the login rate of each source address. Alias lookaheads are limited like logins, and the login
+	// that follows a lookahead of the same signed request was already counted by the lookahead.
+	failureKey := signatureKey(authenticateRequestHeaders)
+	var lookaheadPrincipal *Principal
+	if !lookahead {
+		lookaheadPrincipal = b.loginLimiter.takeLookahead(failureKey)
+	}
+	if lookaheadPrincipal == nil && configEntry != nil && req.Connection != nil &&
+		!b.loginLimiter.allow(req.Connection.RemoteAddr, configEntry.LoginRateLimit, configEntry.LoginRateBurst) {
+		return b.loginErrorResponse(ctx, req, rateLimitedError(fmt.Errorf("too many login requests from this address")))
 	}
 
-	requestMetadata := common.RequestMetadata{
-		nil,
+	if err := validateSignedHeaders(authenticateRequestHeaders, signedHeadersForConfig(configEntry)); err != nil {
+		return b.loginErrorResponse(ctx, req, invalidRequestError(err))
 	}
 
-	authenticateClientRequest := AuthenticateClientRequest{
-		authenticateClientDetails,
-		nil,
-		&req.ID,
-		requestMetadata,
+	// Validate that the request was signed for this Vault server
+	if err := validateServerBinding(authenticateRequestHeaders, configEntry); err != nil {
+		return b.loginErrorResponse(ctx, req, invalidRequestError(err))
 	}
 
-	// Get or create authentication client atomically
-	// Using a local reference prevents race conditions with Invalidate()
-	authClient, err := b.getOrCreateAuthClient(ctx, req.Storage)
+	// Find the targetUrl and Method
+	targetNamespace, err := namespaceInRequestTarget(authenticateRequestHeaders, expectedNamespace(req, configEntry))
 	if err != nil {
-		b.Logger().Error("Failed to create authentication client", "error", err)
-		return logical.ErrorResponse(
-			"Failed to authenticate with OCI Identity service. "+
-				"Ensure Vault is configured with valid credentials. Error: %v", err,
-		), nil
+		return b.loginErrorResponse(ctx, req, invalidRequestError(err))
 	}
-
-	authenticateClientResponse, err := authClient.AuthenticateClient(ctx, authenticateClientRequest)
+	method, targetUrl, err := requestTargetToMethodURL(authenticateRequestHeaders[HdrRequestTarget], targetNamespace, expectedMountPath(req, configEntry), signedRoleName)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return b.loginErrorResponse(ctx, req, invalidRequestError(err))
 	}
-	if authenticateClientResponse.Principal == nil ||
-		len(authenticateClientResponse.Principal.Claims) == 0 ||
-		*authenticateClientResponse.IsSuccess == false {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("OCI authentication failed")), nil
+	b.Logger().Trace(req.ID, "Method:", method, "targetUrl:", targetUrl)
+
+	// Reject denied API keys before making any call to OCI Identity
+	if err := b.checkDeniedKey(ctx, req.Storage, authenticateRequestHeaders); err != nil {
+		return b.loginErrorResponse(ctx, req, err)
 	}
-	internalClaims := FromClaims(authenticateClientResponse.Principal.Claims)
-	principalType := internalClaims.GetString(ClaimPrincipalType)
 
-	// Check the principal type
-	if principalType != PrincipalTypeInstance && principalType != PrincipalTypeUser {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("Wrong principal type")), nil
+	// Reject signatures that failed recently without calling OCI Identity again
+	if b.loginLimiter.recentlyFailed(failureKey) {
+		return b.loginErrorResponse(ctx, req, accessDeniedError(fmt.Errorf("OCI authentication recently failed for this signature")))
+	}
+
+	requestMetadata := common.RequestMetadata{
+		RetryPolicy: nil,
+	}
+
+	// A login that follows the lookahead of the same signed request is not authenticated with OCI Identity again
+	var authClient *AuthenticationClient
+	principal := lookaheadPrincipal
+	if principal != nil {
+		authClient, err = b.getOrCreateAuthClient(ctx, req.Storage)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, upstreamUnavailableError(err))
+		}
+	} else {
+		authClient, principal, err = b.authenticatePrincipal(ctx, req, authenticateRequestHeaders, failureKey, requestMetadata)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
 	}
 
 	b.Logger().Trace("Authentication ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID)
+	span.SetAttributes(attributePrincipalType.String(FromClaims(principal.Claims).GetString(ClaimPrincipalType)))
+	if principal.SubjectId != nil {
+		event.subjectId = *principal.SubjectId
+	}
+	if principal.TenantId != nil {
+		event.tenantId = *principal.TenantId
+	}
+
+	// Reject denied principals
+	if principal.SubjectId != nil {
+		if err := b.checkDeniedPrincipal(ctx, req.Storage, *principal.SubjectId); err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
+	}
 
 	// Validate the home tenancy
-	err = b.validateHomeTenancy(ctx, req, *authenticateClientResponse.Principal.TenantId)
+	err = b.validateHomeTenancy(ctx, req, *principal.TenantId)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		b.loginLimiter.recordFailure(failureKey)
+		return b.loginErrorResponse(ctx, req, accessDeniedError(err))
 	}
 
-	// Find whether the entity corresponding the Principal is a part of any OCIDs allowed to take the role
-	filterGroupMembershipDetails := FilterGroupMembershipDetails{
-		*authenticateClientResponse.Principal,
-		roleEntry.OcidList,
+	subjectId := ""
+	if principal.SubjectId != nil {
+		subjectId = *principal.SubjectId
 	}
 
-	filterGroupMembershipRequest := FilterGroupMembershipRequest{
-		filterGroupMembershipDetails,
-		nil,
-		&req.ID,
-		requestMetadata,
+	// Validate that the role may be taken by this type of principal and credential
+	claims := FromClaims(principal.Claims)
+	principalType := claims.GetString(ClaimPrincipalType)
+	credentialType := credentialTypeOf(authenticateRequestHeaders, claims)
+	if roleEntry != nil {
+		if err := roleEntry.admits(principalType, credentialType); err != nil {
+			return b.loginErrorResponse(ctx, req, accessDeniedError(fmt.Errorf("%s for role %q", err, roleName)))
+		}
 	}
 
-	filterGroupMembershipResponse, err := authClient.FilterGroupMembership(ctx, filterGroupMembershipRequest)
-	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+	// Choose the roles if none was specified. A login for all qualifying roles is issued a
+	// token merged from the roles.
+	var filteredOcidMap map[string]string
+	roleNames := []string{roleName}
+	roleEntries := map[string]*OCIRoleEntry{roleName: roleEntry}
+	if roleEntry == nil {
+		roleNames, roleEntries, filteredOcidMap, err = b.selectRoles(ctx, req, authClient, *principal, principalType, credentialType,
+			configEntry, data.Get("all_roles").(bool), requestMetadata)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
+		roleName = strings.Join(roleNames, ",")
+		roleEntry, err = mergeRoleEntries(roleNames, roleEntries)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, invalidRequestError(err))
+		}
+		b.Logger().Trace(req.ID, "Selected roles", roleName)
+		span.SetAttributes(attributeRoleName.String(roleName))
+		event.roleName = roleName
+	}
+
+	// Enforce the login rate limit and lockout of the roles for this principal. An alias lookahead is
+	// only checked for the lockout, since the login that follows it is counted against the rate limit.
+	admit := b.admitLogin
+	if lookahead {
+		admit = b.checkLockout
 	}
-	if filterGroupMembershipResponse.GroupIds == nil {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("No membership OCIDs found")), nil
+	for _, name := range roleNames {
+		if err := admit(ctx, req.Storage, name, roleEntries[name], subjectId); err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
+	}
+
+	// Find the OCIDs of the role, allowed and denied, that the entity corresponding the Principal is a part of
+	if filteredOcidMap == nil {
+		filteredOcidMap, err = b.filterGroupMembership(ctx, req, authClient, *principal, roleEntry.membershipOcids(), requestMetadata)
+		if err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
 	}
 
-	// Validate that the filtered list contains atleast one of the OCIDs of the Role
-	filteredOcidMap := sliceToMap(filterGroupMembershipResponse.GroupIds)
-	found := false
-	for _, item := range roleEntry.OcidList {
-		_, present := filteredOcidMap[item]
-		if present {
-			found = true
+	// Validate that the filtered list qualifies the principal for the roles
+	var membershipErr error
+	for _, name := range roleNames {
+		if membershipErr = roleEntries[name].checkMembership(filteredOcidMap); membershipErr != nil {
 			break
 		}
 	}
+	found := membershipErr == nil
+
+	// A failed alias lookahead counts towards the lockout like a failed login. A successful one does not
+	// reset the failures, which is left to the login that follows it.
+	if !lookahead || !found {
+		for _, name := range roleNames {
+			if err := b.recordLoginResult(ctx, req.Storage, name, roleEntries[name], subjectId, found); err != nil {
+				return b.loginErrorResponse(ctx, req, err)
+			}
+		}
+	}
 	if found == false {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("Entity not a part of any of the Role OCIDs")), nil
+		return b.loginErrorResponse(ctx, req, accessDeniedError(membershipErr))
 	}
 
-	b.Logger().Trace("Login ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID)
+	opcRequestIds := strings.Join(opcRequestIdsOf(ctx), ",")
+	b.Logger().Trace("Login ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID, "opc_request_ids", opcRequestIds)
+	span.SetAttributes(attributeOutcome.String(outcomeSuccess))
+
+	// The alias is named after the role, unless the config names it after the principal so that each
+	// principal has its own entity, such as for per user login MFA. The lookahead returns the same alias.
+	aliasName := roleName
+	if configEntry != nil && configEntry.aliasSource() == AliasSourceSubjectId {
+		if subjectId == "" {
+			return b.loginErrorResponse(ctx, req, accessDeniedError(fmt.Errorf("The principal has no subject ID to name the alias after")))
+		}
+		aliasName = subjectId
+	}
 
-	// Return the response
+	// Return the response. The metadata is not HMAC'd in the audit log.
 	auth := &logical.Auth{
 		Metadata: map[string]string{
-			"role_name": roleName,
+			"role_name":       roleName,
+			"roles":           roleName,
+			"opc_request_ids": opcRequestIds,
 		},
 		InternalData: map[string]interface{}{
 			"role_name": roleName,
 		},
 		DisplayName: roleName,
 		Alias: &logical.Alias{
-			Name: roleName,
+			Name: aliasName,
 		},
 	}
 
+	if lookahead {
+		b.loginLimiter.recordLookahead(failureKey, principal)
+		return &logical.Response{
+			Auth: &logical.Auth{
+				Alias: auth.Alias,
+			},
+		}, nil
+	}
+
 	roleEntry.PopulateTokenAuth(auth)
-	auth.Renewable = false
+
+	// Tokens are only renewable while they are re-validated, so that the renewal of the tokens of principals
+	// that no longer qualify for their role can be denied
+	auth.Renewable = configEntry != nil && configEntry.TokenRevalidationInterval > 0
+	if auth.Renewable {
+		auth.InternalData["subject_id"] = subjectId
+		if err := b.trackLogin(ctx, req.Storage, *principal, roleNames); err != nil {
+			return b.loginErrorResponse(ctx, req, err)
+		}
+	}
 
 	resp := &logical.Response{
 		Auth: auth,
 	}
+	b.sendLoginSuccessEvent(ctx, req)
+
+	// Record the login in the ledger. The ledger is informational, so the login does not fail without it.
+	sourceIP := ""
+	if req.Connection != nil {
+		sourceIP = req.Connection.RemoteAddr
+	}
+	if err := b.recordLogin(ctx, req.Storage, subjectId, roleNames, sourceIP); err != nil {
+		b.Logger().Warn("unable to record the login in the ledger", "request_id", req.ID, "error", err)
+	}
+
+	return resp, nil
+}
+
+// pathLoginRenew renews a token while its roles exist with the same policies, and while its principal
+// still qualifies for the roles when tokens are re-validated
+func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, _ := req.Auth.InternalData["role_name"].(string)
+	roleNames := strings.Split(roleName, ",")
+	roleEntries := make(map[string]*OCIRoleEntry, len(roleNames))
+	for _, name := range roleNames {
+		roleEntry, err := b.getOCIRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			return nil, fmt.Errorf("role %q does not exist during renewal", name)
+		}
+		roleEntries[name] = roleEntry
+	}
+	roleEntry, err := mergeRoleEntries(roleNames, roleEntries)
+	if err != nil {
+		return nil, err
+	}
 
+	if !policyutil.EquivalentPolicies(roleEntry.TokenPolicies, req.Auth.TokenPolicies) {
+		return nil, fmt.Errorf("policies on role %q have changed, cannot renew", roleName)
+	}
+
+	if subjectId, _ := req.Auth.InternalData["subject_id"].(string); subjectId != "" {
+		if err := b.trackRenewal(ctx, req.Storage, subjectId, req.Auth.Accessor, roleNames); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.TTL = roleEntry.TokenTTL
+	resp.Auth.MaxTTL = roleEntry.TokenMaxTTL
+	resp.Auth.Period = roleEntry.TokenPeriod
 	return resp, nil
 }
 
@@ -268,12 +466,267 @@ func (b *backend) validateHomeTenancy(ctx context.Context, req *logical.Request,
 	return nil
 }
 
-func badRequestLogicalResponse(req *logical.Request, logger log.Logger, err error) *logical.Response {
-	logger.Trace(req.ID, ": Failed with error:", err)
-	return logical.ErrorResponse(err.Error())
+// authenticatePrincipal forwards the signed headers to OCI Identity and returns the authenticated user or
+// instance principal. Signatures that OCI Identity rejects are remembered under failureKey, so that logins do
+// not retry them. Requests other than logins pass an empty failureKey and are not remembered.
+func (b *backend) authenticatePrincipal(ctx context.Context, req *logical.Request, requestHeaders http.Header,
+	failureKey string, requestMetadata common.RequestMetadata) (*AuthenticationClient, *Principal, error) {
+
+	// Forward only the signed headers and the signature to OCI Identity
+	forwardedHeaders, err := filterSignedHeaders(requestHeaders)
+	if err != nil {
+		return nil, nil, invalidRequestError(err)
+	}
+
+	authenticateClientDetails := AuthenticateClientDetails{
+		RequestHeaders: forwardedHeaders,
+	}
+
+	authenticateClientRequest := AuthenticateClientRequest{
+		authenticateClientDetails,
+		nil,
+		&req.ID,
+		requestMetadata,
+	}
+
+	// Get or create authentication client atomically
+	// Using a local reference prevents race conditions with Invalidate()
+	authClient, err := b.getOrCreateAuthClient(ctx, req.Storage)
+	if err != nil {
+		b.Logger().Error("Failed to create authentication client", "error", err)
+		return nil, nil, upstreamUnavailableError(fmt.Errorf("Failed to authenticate with OCI Identity service. "+
+			"Ensure Vault is configured with valid credentials. Error: %v", err))
+	}
+
+	recordFailure := func() {
+		if failureKey != "" {
+			b.loginLimiter.recordFailure(failureKey)
+		}
+	}
+
+	authenticateClientResponse, err := authClient.AuthenticateClient(ctx, authenticateClientRequest)
+	if err != nil {
+		recordOpcRequestIdOfError(ctx, err)
+		if isAuthenticationFailure(err) {
+			recordFailure()
+		}
+		return nil, nil, upstreamError(err)
+	}
+	b.Logger().Debug("authenticated with OCI Identity", "request_id", req.ID,
+		"opc_request_id", recordOpcRequestId(ctx, authenticateClientResponse.OpcRequestId))
+
+	if authenticateClientResponse.Principal == nil ||
+		len(authenticateClientResponse.Principal.Claims) == 0 ||
+		authenticateClientResponse.IsSuccess == nil ||
+		*authenticateClientResponse.IsSuccess == false {
+		recordFailure()
+		return nil, nil, accessDeniedError(fmt.Errorf("OCI authentication failed"))
+	}
+	internalClaims := FromClaims(authenticateClientResponse.Principal.Claims)
+	principalType := internalClaims.GetString(ClaimPrincipalType)
+
+	// Check the principal type
+	if !strutil.StrListContains(principalTypes, principalType) {
+		recordFailure()
+		return nil, nil, accessDeniedError(fmt.Errorf("Wrong principal type"))
+	}
+
+	return authClient, authenticateClientResponse.Principal, nil
+}
+
+// filterGroupMembership returns the OCIDs among the given groups and dynamic groups that the principal
+// is a member of. The OCIDs are filtered in batches of at most MaxOCIDsPerRole.
+func (b *backend) filterGroupMembership(ctx context.Context, req *logical.Request, authClient *AuthenticationClient,
+	principal Principal, ocids []string, requestMetadata common.RequestMetadata) (map[string]string, error) {
+
+	filteredOcids := []string{}
+	for start := 0; start < len(ocids); start += MaxOCIDsPerRole {
+		end := start + MaxOCIDsPerRole
+		if end > len(ocids) {
+			end = len(ocids)
+		}
+
+		filterGroupMembershipRequest := FilterGroupMembershipRequest{
+			FilterGroupMembershipDetails{
+				principal,
+				ocids[start:end],
+			},
+			nil,
+			&req.ID,
+			requestMetadata,
+		}
+
+		filterGroupMembershipResponse, err := authClient.FilterGroupMembership(ctx, filterGroupMembershipRequest)
+		if err != nil {
+			recordOpcRequestIdOfError(ctx, err)
+			return nil, upstreamError(err)
+		}
+		b.Logger().Debug("filtered group membership with OCI Identity", "request_id", req.ID,
+			"opc_request_id", recordOpcRequestId(ctx, filterGroupMembershipResponse.OpcRequestId))
+
+		if filterGroupMembershipResponse.GroupIds == nil {
+			return nil, upstreamError(fmt.Errorf("No membership OCIDs found"))
+		}
+		filteredOcids = append(filteredOcids, filterGroupMembershipResponse.GroupIds...)
+	}
+
+	return sliceToMap(filteredOcids), nil
+}
+
+// selectRoles chooses the roles for a login that did not specify one, from the group membership of the principal.
+// The roles in the configured role_priority are tried in order and the first one the principal qualifies for
+// is chosen. Without a role_priority, all roles are considered and the principal must qualify for exactly one.
+// If allRoles is set, every role the principal qualifies for is chosen, in order. Roles that may not be taken by the
+// type of principal or credential are not considered.
+func (b *backend) selectRoles(ctx context.Context, req *logical.Request, authClient *AuthenticationClient, principal Principal,
+	principalType, credentialType string, configEntry *OCIConfigEntry, allRoles bool,
+	requestMetadata common.RequestMetadata) ([]string, map[string]*OCIRoleEntry, map[string]string, error) {
+
+	var candidates []string
+	if configEntry != nil {
+		candidates = configEntry.RolePriority
+	}
+	prioritized := len(candidates) > 0
+	if !prioritized {
+		var err error
+		candidates, err = req.Storage.List(ctx, "role/")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Collect the OCIDs of all candidate roles, so that a single membership check covers them
+	roleNames := []string{}
+	roleEntries := make(map[string]*OCIRoleEntry, len(candidates))
+	ocids := []string{}
+	notAdmitted := 0
+	for _, name := range candidates {
+		roleEntry, err := b.getOCIRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if roleEntry == nil {
+			continue
+		}
+		if roleEntry.admits(principalType, credentialType) != nil {
+			notAdmitted++
+			continue
+		}
+		roleNames = append(roleNames, name)
+		roleEntries[name] = roleEntry
+		ocids = append(ocids, roleEntry.membershipOcids()...)
+	}
+	if len(roleNames) == 0 && notAdmitted > 0 {
+		return nil, nil, nil, accessDeniedError(fmt.Errorf("No role allows principal type %q with credential type %q", principalType, credentialType))
+	}
+	if len(roleNames) == 0 {
+		return nil, nil, nil, invalidRequestError(fmt.Errorf("Role is not specified and no roles are available to choose from"))
+	}
+
+	filteredOcidMap, err := b.filterGroupMembership(ctx, req, authClient, principal, strutil.RemoveDuplicates(ocids, false), requestMetadata)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	qualifyingRoles := []string{}
+	for _, name := range roleNames {
+		if roleEntries[name].qualifies(filteredOcidMap) {
+			qualifyingRoles = append(qualifyingRoles, name)
+		}
+	}
+	if len(qualifyingRoles) == 0 {
+		return nil, nil, nil, accessDeniedError(fmt.Errorf("Entity not a part of any of the Role OCIDs"))
+	}
+	if !allRoles {
+		if !prioritized && len(qualifyingRoles) > 1 {
+			return nil, nil, nil, invalidRequestError(fmt.Errorf("Role is ambiguous, the entity qualifies for the roles %s. Specify the role or configure role_priority",
+				strings.Join(qualifyingRoles, ", ")))
+		}
+		qualifyingRoles = qualifyingRoles[:1]
+	}
+
+	return qualifyingRoles, roleEntries, filteredOcidMap, nil
+}
+
+// expectedMountPath returns the path below auth/ that this mount is expected to be reached at.
+// The configured expected_mount_path takes precedence over the mount point reported by Vault.
+func expectedMountPath(req *logical.Request, configEntry *OCIConfigEntry) string {
+	if configEntry != nil && configEntry.ExpectedMountPath != "" {
+		return configEntry.ExpectedMountPath
+	}
+	return normalizeMountPath(req.MountPoint)
+}
+
+// expectedNamespace returns the path of the namespace that this mount is expected to be reached in,
+// or "" for the root namespace. The configured expected_namespace takes precedence over the namespace
+// of the mount point reported by Vault.
+func expectedNamespace(req *logical.Request, configEntry *OCIConfigEntry) string {
+	if configEntry != nil && configEntry.ExpectedNamespace != "" {
+		return configEntry.ExpectedNamespace
+	}
+	return namespaceOfMountPoint(req.MountPoint)
+}
+
+// normalizeMountPath turns a mount point such as "auth/oci/" or "ns1/auth/team/oci/" into
+// the path of the mount below auth/, such as "oci" or "team/oci"
+func normalizeMountPath(mountPath string) string {
+	mountPath = strings.Trim(mountPath, "/")
+	if strings.HasPrefix(mountPath, PathSegmentAuth+"/") {
+		return strings.TrimPrefix(mountPath, PathSegmentAuth+"/")
+	}
+	if i := strings.Index(mountPath, "/"+PathSegmentAuth+"/"); i >= 0 {
+		return mountPath[i+len(PathSegmentAuth)+2:]
+	}
+	return mountPath
+}
+
+// namespaceOfMountPoint returns the namespace of a mount point such as "ns1/ns2/auth/oci/", which is
+// "ns1/ns2", or "" for a mount point in the root namespace such as "auth/oci/"
+func namespaceOfMountPoint(mountPoint string) string {
+	mountPoint = strings.Trim(mountPoint, "/")
+	if i := strings.Index(mountPoint, "/"+PathSegmentAuth+"/"); i >= 0 {
+		return mountPoint[:i]
+	}
+	return ""
+}
+
+// normalizeNamespace turns a namespace such as "/ns1/ns2/" into "ns1/ns2"
+func normalizeNamespace(namespace string) string {
+	return strings.Trim(namespace, "/")
+}
+
+// namespaceInRequestTarget returns the part of the namespace that the (request-target) must name.
+// Vault prefixes the path of the request with the X-Vault-Namespace header, so when the signature
+// covers that header, the URL names only the rest of the namespace.
+func namespaceInRequestTarget(requestHeaders http.Header, namespace string) (string, error) {
+	signedHeaders, err := parseSignedHeaders(requestHeaders)
+	if err != nil {
+		return "", err
+	}
+	if !strutil.StrListContains(signedHeaders, HdrVaultNamespace) {
+		return namespace, nil
+	}
+
+	namespaceHeaders := requestHeaders.Values(HdrVaultNamespace)
+	if len(namespaceHeaders) != 1 {
+		return "", fmt.Errorf("a single %s must be specified in header", HdrVaultNamespace)
+	}
+	signedNamespace := normalizeNamespace(namespaceHeaders[0])
+	switch {
+	case signedNamespace == namespace:
+		return "", nil
+	case signedNamespace != "" && strings.HasPrefix(namespace, signedNamespace+"/"):
+		return strings.TrimPrefix(namespace, signedNamespace+"/"), nil
+	}
+	return "", fmt.Errorf("%s was not signed for this namespace", HdrVaultNamespace)
 }
 
-func requestTargetToMethodURL(requestTarget []string, roleName string) (method string, url string, err error) {
+// requestTargetToMethodURL validates the (request-target) header and returns the method and URL it names.
+// The URL must have the form /v1/[<namespace>/]auth/<mount>/login/<role>, where the mount may be nested,
+// or /v1/[<namespace>/]auth/<mount>/login if roleName is empty. The namespace and the mount must be exactly
+// the given ones, so that a signature made for one namespace can not be replayed in another.
+// If mountPath is empty, only the presence of a mount segment is validated.
+func requestTargetToMethodURL(requestTarget []string, namespace string, mountPath string, roleName string) (method string, url string, err error) {
 	if len(requestTarget) == 0 {
 		return "", "", errors.New("no (request-target) specified in header")
 	}
@@ -290,14 +743,45 @@ func requestTargetToMethodURL(requestTarget []string, roleName string) (method s
 		return "", "", errHeader
 	}
 
-	// Validate the URL path by inspecting its segments.
-	// The path mount segment of the URL is not validated.
+	// Validate the URL path by inspecting its segments
 	segments := strings.Split(strings.TrimPrefix(parts[1], "/"), "/")
-	if len(segments) < 5 || segments[0] != PathSegmentVersion || segments[1] != PathSegmentAuth ||
-		segments[len(segments)-2] != PathSegmentLogin || segments[len(segments)-1] != roleName {
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", errHeader
+		}
+	}
+	loginSegments := []string{PathSegmentLogin}
+	if roleName != "" {
+		loginSegments = append(loginSegments, roleName)
+	}
+	if len(segments) < len(loginSegments)+3 || segments[0] != PathSegmentVersion ||
+		strings.Join(segments[len(segments)-len(loginSegments):], "/") != strings.Join(loginSegments, "/") {
 		return "", "", errHeader
 	}
 
+	// Validate the mount and the namespace preceding it
+	mountSegments := segments[1 : len(segments)-len(loginSegments)]
+	if mountPath == "" {
+		authIndex := -1
+		for i, segment := range mountSegments {
+			if segment == PathSegmentAuth {
+				authIndex = i
+				break
+			}
+		}
+		if authIndex < 0 || authIndex == len(mountSegments)-1 {
+			return "", "", errHeader
+		}
+	} else {
+		expected := PathSegmentAuth + "/" + mountPath
+		if namespace != "" {
+			expected = namespace + "/" + expected
+		}
+		if strings.Join(mountSegments, "/") != expected {
+			return "", "", fmt.Errorf("(request-target) was not signed for this mount")
+		}
+	}
+
 	return parts[0], parts[1], nil
 }
 
@@ -310,9 +794,22 @@ Authenticates to Vault using OCI credentials such as User Api Key, Instance Prin
 `
 
 const pathLoginSyn = `
-Determines the role that would be used for login from a valid OCI login request
+Authenticates to Vault using OCI credentials, choosing the role from the group membership of the principal
 `
 
 const pathLoginDesc = `
-Determines the role that would be used for login from a valid OCI login request
+Authenticates to Vault using OCI credentials such as User Api Key, Instance Principal,
+without specifying a role. The role is chosen from the group membership of the
+principal: the roles in the configured role_priority are tried in order, and the
+first one the principal qualifies for is used. Without a role_priority, the
+principal must qualify for exactly one role. The login request must be signed for
+/v1/auth/<mount>/login.
+
+With all_roles=true, the token is issued for all roles the principal qualifies
+for. It carries the union of their policies and the shortest of their TTLs, and
+its roles metadata lists the contributing roles. The roles must have the same
+token_bound_cidrs, token_type and token_no_default_policy, and either all or
+none of them must set token_period.
+
+Also determines the role that would be used for login from a valid OCI login request.
 `
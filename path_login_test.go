@@ -3,10 +3,19 @@ package ociauth
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
 )
 
 func TestResolveRole(t *testing.T) {
@@ -98,3 +107,911 @@ func TestResolveRole_RoleDoesNotExist(t *testing.T) {
 		t.Fatalf("Error was not due to invalid role name. Error: %s", errString)
 	}
 }
+
+// signTestLoginRequest signs a login request for the given path with a generated API key,
+// the same way the CLI does
+func signTestLoginRequest(t *testing.T, addr, path string, extraHeaders map[string]string) http.Header {
+	t.Helper()
+
+	key := generateTestKey(t)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	provider := common.NewRawConfigurationProvider("ocid1.tenancy.oc1..aaaatest", "ocid1.user.oc1..bbbbtest",
+		"us-phoenix-1", "aa:bb:cc:dd", string(keyPEM), nil)
+
+	client, err := NewOciClientWithConfigurationProvider(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := getSignedRequestHeaders(addr, &client, path, extraHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return headers
+}
+
+// loginErrorOf returns the HTTP status, error code and message of a failed login
+func loginErrorOf(t *testing.T, resp *logical.Response, err error) (int, string, string) {
+	t.Helper()
+
+	codedErr, ok := err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() < 400 {
+		t.Fatalf("expected a failed login error, got resp:%#v err:%v", resp, err)
+	}
+	if resp == nil || resp.Data["error"] != codedErr.Error() {
+		t.Fatalf("expected a response with the error of the failed login, got: %#v", resp)
+	}
+
+	errorCode, _ := resp.Data["error_code"].(string)
+	return codedErr.Code(), errorCode, codedErr.Error()
+}
+
+// setupTestLoginBackend creates a backend with a config and a role that logins can be attempted against
+func setupTestLoginBackend(t *testing.T, role string, configData map[string]interface{}) (*backend, logical.Storage) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	if configData == nil {
+		configData = map[string]interface{}{}
+	}
+	configData[HomeTenancyIdConfigName] = "ocid1.tenancy.oc1..aaaatest"
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data:      configData,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config creation failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	roleData := map[string]interface{}{
+		"ocid_list":      "ocid1,ocid2",
+		"token_policies": "policy1",
+	}
+	if err := createRole(roleData, role, b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, config.StorageView
+}
+
+func TestLogin_RequiredSignedHeaders(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	assertRejected := func(b *backend, storage logical.Storage, headers http.Header, reason string) {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + role,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		status, errorCode, errString := loginErrorOf(t, resp, err)
+		if status != http.StatusBadRequest || errorCode != ErrorCodeInvalidRequest {
+			t.Fatalf("expected an invalid request, got %d %q", status, errorCode)
+		}
+		if !strings.Contains(errString, reason) {
+			t.Fatalf("expected error to contain %q, got: %q", reason, errString)
+		}
+		// The request must be rejected before any client to OCI Identity is created
+		if b.authenticationClient != nil {
+			t.Fatal("expected no authentication client to be created")
+		}
+	}
+
+	t.Run("MissingHost", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, role, nil)
+
+		headers := signTestLoginRequest(t, "https://vault.example.com", signingPath, nil)
+		authorization := headers.Get(HdrAuthorization)
+		headers.Set(HdrAuthorization, strings.Replace(authorization, `headers="date (request-target) host"`, `headers="date (request-target)"`, 1))
+
+		assertRejected(b, storage, headers, `"host"`)
+	})
+
+	t.Run("MissingAdditionalHeader", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, role, map[string]interface{}{
+			"required_signed_headers": "x-vault-oci-test",
+		})
+
+		headers := signTestLoginRequest(t, "https://vault.example.com", signingPath, nil)
+		assertRejected(b, storage, headers, `"x-vault-oci-test"`)
+	})
+}
+
+func TestLogin_ServerBinding(t *testing.T) {
+	role := "testrole"
+	signingPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	b, storage := setupTestLoginBackend(t, role, map[string]interface{}{
+		"allowed_hosts":          "vault.example.com",
+		"server_id_header_value": "vault-prod",
+	})
+
+	for name, headers := range map[string]http.Header{
+		"OtherServerID": signTestLoginRequest(t, "https://vault.example.com", signingPath, map[string]string{HdrVaultServerID: "vault-dev"}),
+		"OtherHost":     signTestLoginRequest(t, "https://vault-dev.example.com", signingPath, map[string]string{HdrVaultServerID: "vault-prod"}),
+		"NoServerID":    signTestLoginRequest(t, "https://vault.example.com", signingPath, nil),
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + role,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if status, _, _ := loginErrorOf(t, resp, err); status != http.StatusBadRequest {
+			t.Fatalf("%s: expected login to be rejected as an invalid request, got %d", name, status)
+		}
+	}
+
+	if b.authenticationClient != nil {
+		t.Fatal("expected no authentication client to be created")
+	}
+}
+
+func TestRequestTargetToMethodURL(t *testing.T) {
+	accepted := []struct {
+		target    string
+		namespace string
+		mountPath string
+	}{
+		{"get /v1/auth/oci/login/devrole", "", ""},
+		{"get /v1/auth/oci/login/devrole", "", "oci"},
+		{"get /v1/auth/team/oci/login/devrole", "", "team/oci"},
+		{"get /v1/ns1/auth/oci/login/devrole", "ns1", "oci"},
+		{"get /v1/ns1/ns2/auth/team/oci/login/devrole", "ns1/ns2", "team/oci"},
+		{"get /v1/ns1/auth/oci/login/devrole", "", ""},
+	}
+	for _, tc := range accepted {
+		if _, _, err := requestTargetToMethodURL([]string{tc.target}, tc.namespace, tc.mountPath, "devrole"); err != nil {
+			t.Fatalf("expected %q for mount %q in namespace %q to be accepted: %v", tc.target, tc.mountPath, tc.namespace, err)
+		}
+	}
+
+	// Logins without a role are signed for the login path itself
+	if _, _, err := requestTargetToMethodURL([]string{"get /v1/auth/oci/login"}, "", "oci", ""); err != nil {
+		t.Fatalf("expected a login without a role to be accepted: %v", err)
+	}
+	for _, target := range []string{"get /v1/auth/oci/login/devrole", "get /v1/auth/login", "get /v1/auth/oci"} {
+		if _, _, err := requestTargetToMethodURL([]string{target}, "", "", ""); err == nil {
+			t.Fatalf("expected %q without a role to be rejected", target)
+		}
+	}
+	rejected := []struct {
+		target    string
+		namespace string
+		mountPath string
+	}{
+		{"get /v1/auth/oci-dev/login/devrole", "", "oci-prod"},
+		{"get /v1/auth/oci/login/devrole", "", "team/oci"},
+		{"get /v1/auth/team/oci/login/devrole", "", "oci"},
+		{"get /v1/auth/oci-prod/../oci-dev/login/devrole", "", "oci-dev"},
+		{"get /v1/auth//login/devrole", "", ""},
+		{"get /v1/sys/oci/login/devrole", "", ""},
+		{"get /v1/auth/oci/login/opsrole", "", "oci"},
+		{"post /v1/auth/oci/login/devrole", "", "oci"},
+		// Signatures made for another namespace are not replayed in this one
+		{"get /v1/ns1/auth/oci/login/devrole", "", "oci"},
+		{"get /v1/ns1/auth/oci/login/devrole", "ns2", "oci"},
+		{"get /v1/ns0/ns1/auth/oci/login/devrole", "ns1", "oci"},
+		{"get /v1/auth/oci/login/devrole", "ns1", "oci"},
+	}
+	for _, tc := range rejected {
+		if _, _, err := requestTargetToMethodURL([]string{tc.target}, tc.namespace, tc.mountPath, "devrole"); err == nil {
+			t.Fatalf("expected %q for mount %q in namespace %q to be rejected", tc.target, tc.mountPath, tc.namespace)
+		}
+	}
+}
+
+func TestNamespaceInRequestTarget(t *testing.T) {
+	for mountPoint, namespace := range map[string]string{
+		"auth/oci/":          "",
+		"auth/team/oci/":     "",
+		"ns1/auth/oci/":      "ns1",
+		"ns1/ns2/auth/oci/":  "ns1/ns2",
+		"/ns1/auth/team/oci": "ns1",
+	} {
+		if actual := namespaceOfMountPoint(mountPoint); actual != namespace {
+			t.Fatalf("expected the namespace of %q to be %q, got %q", mountPoint, namespace, actual)
+		}
+	}
+
+	headersSigning := func(namespace string) http.Header {
+		headers := http.Header{}
+		headers.Set(HdrAuthorization, `Signature version="1",headers="date (request-target) host x-vault-namespace",keyId="key",signature="sig"`)
+		headers.Set(HdrVaultNamespace, namespace)
+		return headers
+	}
+	unsigned := http.Header{}
+	unsigned.Set(HdrAuthorization, `Signature version="1",headers="date (request-target) host",keyId="key",signature="sig"`)
+	unsigned.Set(HdrVaultNamespace, "ns1")
+
+	accepted := []struct {
+		headers   http.Header
+		namespace string
+		expected  string
+	}{
+		{unsigned, "ns1", "ns1"},
+		{headersSigning("ns1"), "ns1", ""},
+		{headersSigning("/ns1/"), "ns1", ""},
+		{headersSigning("ns1"), "ns1/ns2", "ns2"},
+	}
+	for _, tc := range accepted {
+		actual, err := namespaceInRequestTarget(tc.headers, tc.namespace)
+		if err != nil || actual != tc.expected {
+			t.Fatalf("expected %q for namespace %q, got %q: %v", tc.expected, tc.namespace, actual, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		headers   http.Header
+		namespace string
+	}{
+		{headersSigning("ns2"), "ns1"},
+		{headersSigning("ns1"), ""},
+		{headersSigning("ns1/ns2"), "ns1"},
+		{headersSigning("ns"), "ns1"},
+	} {
+		if _, err := namespaceInRequestTarget(tc.headers, tc.namespace); err == nil {
+			t.Fatalf("expected the signed namespace %q to be rejected in namespace %q", tc.headers.Get(HdrVaultNamespace), tc.namespace)
+		}
+	}
+}
+
+func TestNormalizeMountPath(t *testing.T) {
+	for mountPoint, expected := range map[string]string{
+		"auth/oci/":          "oci",
+		"auth/team/oci/":     "team/oci",
+		"ns1/auth/oci/":      "oci",
+		"/oci-prod/":         "oci-prod",
+		"":                   "",
+		"auth/oci-dev":       "oci-dev",
+		"ns1/ns2/auth/a/b/c": "a/b/c",
+	} {
+		if actual := normalizeMountPath(mountPoint); actual != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, mountPoint, actual)
+		}
+	}
+}
+
+func TestLogin_OtherMount(t *testing.T) {
+	role := "testrole"
+	b, storage := setupTestLoginBackend(t, role, nil)
+
+	headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci-dev", role), nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation:  logical.UpdateOperation,
+		Path:       "login/" + role,
+		MountPoint: "auth/oci-prod/",
+		Storage:    storage,
+		Data: map[string]interface{}{
+			"request_headers": headers,
+		},
+	})
+	if _, _, errString := loginErrorOf(t, resp, err); !strings.Contains(errString, "mount") {
+		t.Fatalf("unexpected error: %q", errString)
+	}
+}
+
+// fakeIdentity stands in for OCI Identity. It authenticates every request as its principal,
+// and reports the principal as a member of its groups.
+type fakeIdentity struct {
+	subjectId     string
+	principalType string
+	groupIds      []string
+
+	// The requests received, keyed on the path
+	mutex    sync.Mutex
+	requests map[string][]*http.Request
+}
+
+func (f *fakeIdentity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	if f.requests == nil {
+		f.requests = make(map[string][]*http.Request)
+	}
+	f.requests[r.URL.Path] = append(f.requests[r.URL.Path], r)
+	f.mutex.Unlock()
+
+	principal := Principal{
+		TenantId:  common.String("ocid1.tenancy.oc1..aaaatest"),
+		SubjectId: common.String(f.subjectId),
+		Claims: []Claim{
+			{Key: common.String(ClaimPrincipalType), Value: common.String(f.principalType), Issuer: common.String("authService.oracle.com")},
+		},
+	}
+
+	var result interface{}
+	switch r.URL.Path {
+	case "/v1/authentication/authenticateClient":
+		result = AuthenticateClientResult{Principal: &principal, IsSuccess: common.Bool(true)}
+	case "/v1/filterGroupMembership":
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		memberOf := sliceToMap(f.groupIds)
+		groupIds := []string{}
+		for _, groupId := range details.GroupIds {
+			if _, ok := memberOf[groupId]; ok {
+				groupIds = append(groupIds, groupId)
+			}
+		}
+		result = FilterGroupMembershipResult{Principal: principal, GroupIds: groupIds}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("opc-request-id", "identity"+strings.TrimPrefix(r.URL.Path, "/v1"))
+	json.NewEncoder(w).Encode(result)
+}
+
+// useFakeIdentity makes the backend authenticate logins against the fake OCI Identity
+func useFakeIdentity(t *testing.T, b *backend, identity *fakeIdentity) {
+	t.Helper()
+
+	server := httptest.NewServer(identity)
+	t.Cleanup(server.Close)
+
+	key := generateTestKey(t)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	provider := common.NewRawConfigurationProvider("ocid1.tenancy.oc1..aaaatest", "ocid1.user.oc1..vault",
+		"us-phoenix-1", "aa:bb:cc:dd", string(keyPEM), nil)
+
+	authClient, err := NewAuthenticationClientWithConfigurationProvider(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authClient.SetHost(server.URL)
+
+	b.authClientMutex.Lock()
+	b.authenticationClient = &authClient
+	b.authClientMutex.Unlock()
+}
+
+func TestLogin_SelectRole(t *testing.T) {
+	loginPath := PathVersionBase + fmt.Sprintf(PathLoginFormat, "oci")
+
+	setup := func(t *testing.T, configData map[string]interface{}, groupIds ...string) (*backend, logical.Storage) {
+		b, storage := setupTestLoginBackend(t, "devrole", configData)
+		config := &logical.BackendConfig{StorageView: storage}
+		if err := createRole(map[string]interface{}{"ocid_list": "ocid3", "token_policies": "ops,policy1", "token_ttl": "10m"}, "opsrole", b, config); err != nil {
+			t.Fatal(err)
+		}
+		useFakeIdentity(t, b, &fakeIdentity{
+			subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+			principalType: PrincipalTypeInstance,
+			groupIds:      groupIds,
+		})
+		return b, storage
+	}
+
+	login := func(t *testing.T, b *backend, storage logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+		t.Helper()
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		data["request_headers"] = signTestLoginRequest(t, "https://vault.example.com", loginPath, nil)
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	t.Run("SingleRole", func(t *testing.T) {
+		b, storage := setup(t, nil, "ocid3")
+		resp, err := login(t, b, storage, nil)
+		if err != nil || resp == nil || resp.IsError() || resp.Auth == nil {
+			t.Fatalf("expected login to succeed, got resp:%#v err:%v", resp, err)
+		}
+		if resp.Auth.Metadata["role_name"] != "opsrole" {
+			t.Fatalf("expected opsrole to be selected, got: %#v", resp.Auth.Metadata)
+		}
+		if resp.Auth.Metadata["opc_request_ids"] != "identity/authentication/authenticateClient,identity/filterGroupMembership" {
+			t.Fatalf("unexpected opc_request_ids metadata: %#v", resp.Auth.Metadata)
+		}
+	})
+
+	t.Run("Ambiguous", func(t *testing.T) {
+		b, storage := setup(t, nil, "ocid1", "ocid3")
+		resp, err := login(t, b, storage, nil)
+		if _, _, errString := loginErrorOf(t, resp, err); !strings.Contains(errString, "ambiguous") {
+			t.Fatalf("unexpected error: %q", errString)
+		}
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		b, storage := setup(t, map[string]interface{}{"role_priority": "opsrole,devrole"}, "ocid1", "ocid3")
+		resp, err := login(t, b, storage, nil)
+		if err != nil || resp == nil || resp.IsError() || resp.Auth == nil {
+			t.Fatalf("expected login to succeed, got resp:%#v err:%v", resp, err)
+		}
+		if resp.Auth.Metadata["role_name"] != "opsrole" {
+			t.Fatalf("expected opsrole to be selected, got: %#v", resp.Auth.Metadata)
+		}
+	})
+
+	t.Run("NoRole", func(t *testing.T) {
+		b, storage := setup(t, nil, "ocid9")
+		resp, err := login(t, b, storage, nil)
+		if status, errorCode, _ := loginErrorOf(t, resp, err); status != http.StatusForbidden || errorCode != ErrorCodeAccessDenied {
+			t.Fatalf("expected access to be denied, got %d %q", status, errorCode)
+		}
+		if opcRequestIds, _ := resp.Data["opc_request_ids"].([]string); strings.Join(opcRequestIds, ",") != "identity/authentication/authenticateClient,identity/filterGroupMembership" {
+			t.Fatalf("unexpected opc_request_ids: %v", resp.Data["opc_request_ids"])
+		}
+	})
+
+	t.Run("AllRoles", func(t *testing.T) {
+		b, storage := setup(t, nil, "ocid1", "ocid3")
+		resp, err := login(t, b, storage, map[string]interface{}{"all_roles": true})
+		if err != nil || resp == nil || resp.IsError() || resp.Auth == nil {
+			t.Fatalf("expected login to succeed, got resp:%#v err:%v", resp, err)
+		}
+		if resp.Auth.Metadata["roles"] != "devrole,opsrole" {
+			t.Fatalf("unexpected roles metadata: %#v", resp.Auth.Metadata)
+		}
+		if strings.Join(resp.Auth.Policies, ",") != "policy1,ops" {
+			t.Fatalf("expected the union of the policies, got: %v", resp.Auth.Policies)
+		}
+		if resp.Auth.TTL != 10*time.Minute {
+			t.Fatalf("expected the shortest TTL, got: %v", resp.Auth.TTL)
+		}
+	})
+
+	t.Run("AllRolesDifferentBoundCIDRs", func(t *testing.T) {
+		b, storage := setup(t, nil, "ocid1", "ocid3")
+		for roleName, cidrs := range map[string]string{"devrole": "10.0.0.0/8", "opsrole": "192.168.0.0/16"} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/" + roleName,
+				Storage:   storage,
+				Data:      map[string]interface{}{"token_bound_cidrs": cidrs},
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("Role update failed. resp:%#v\n err:%v", resp, err)
+			}
+		}
+
+		// A token bound to the CIDRs of one role would escape the CIDRs of the other
+		resp, err := login(t, b, storage, map[string]interface{}{"all_roles": true})
+		if resp != nil && resp.Auth != nil {
+			t.Fatalf("expected roles with different token_bound_cidrs not to be merged, got policies %v bound to %v", resp.Auth.Policies, resp.Auth.BoundCIDRs)
+		}
+		if status, errorCode, errString := loginErrorOf(t, resp, err); status != http.StatusBadRequest || errorCode != ErrorCodeInvalidRequest || !strings.Contains(errString, "token_bound_cidrs") {
+			t.Fatalf("unexpected error: %d %q %q", status, errorCode, errString)
+		}
+
+		// Roles with the same bound CIDRs are still merged
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/opsrole",
+			Storage:   storage,
+			Data:      map[string]interface{}{"token_bound_cidrs": "10.0.0.0/8"},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Role update failed. resp:%#v\n err:%v", resp, err)
+		}
+		resp, err = login(t, b, storage, map[string]interface{}{"all_roles": true})
+		if err != nil || resp == nil || resp.IsError() || resp.Auth == nil || len(resp.Auth.BoundCIDRs) != 1 || resp.Auth.BoundCIDRs[0].String() != "10.0.0.0/8" {
+			t.Fatalf("expected login to succeed with the bound CIDRs of the roles, got resp:%#v err:%v", resp, err)
+		}
+	})
+
+	t.Run("AllRolesPeriodicAndNonPeriodic", func(t *testing.T) {
+		b, storage := setup(t, nil, "ocid1", "ocid3")
+		for roleName, data := range map[string]map[string]interface{}{
+			"devrole": {"token_period": "1h"},
+			"opsrole": {"token_max_ttl": "2h"},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "role/" + roleName,
+				Storage:   storage,
+				Data:      data,
+			})
+			if err != nil || (resp != nil && resp.IsError()) {
+				t.Fatalf("Role update failed. resp:%#v\n err:%v", resp, err)
+			}
+		}
+
+		// A periodic token would outlive the token_max_ttl of the non-periodic role
+		resp, err := login(t, b, storage, map[string]interface{}{"all_roles": true})
+		if resp != nil && resp.Auth != nil {
+			t.Fatalf("expected a periodic and a non-periodic role not to be merged, got period %v", resp.Auth.Period)
+		}
+		if status, errorCode, errString := loginErrorOf(t, resp, err); status != http.StatusBadRequest || errorCode != ErrorCodeInvalidRequest || !strings.Contains(errString, "token_period") {
+			t.Fatalf("unexpected error: %d %q %q", status, errorCode, errString)
+		}
+	})
+}
+
+func TestLogin_AliasLookahead(t *testing.T) {
+	role := "testrole"
+	b, storage := setupTestLoginBackend(t, role, nil)
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     "ocid1.user.oc1..bbbbtest",
+		principalType: PrincipalTypeUser,
+		groupIds:      []string{"ocid1"},
+	})
+
+	headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role), nil)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.AliasLookaheadOperation,
+		Path:      "login/" + role,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"request_headers": headers,
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Alias lookahead failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Auth == nil || resp.Auth.Alias == nil || resp.Auth.Alias.Name != role {
+		t.Fatalf("unexpected alias: %#v", resp.Auth)
+	}
+	if len(resp.Auth.Policies) != 0 {
+		t.Fatalf("expected no token to be issued, got policies: %v", resp.Auth.Policies)
+	}
+
+	// The lookahead authenticates the login like the login itself
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     "ocid1.user.oc1..bbbbtest",
+		principalType: PrincipalTypeUser,
+		groupIds:      []string{"ocid9"},
+	})
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.AliasLookaheadOperation,
+		Path:      "login/" + role,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"request_headers": headers,
+		},
+	})
+	if status, _, _ := loginErrorOf(t, resp, err); status != http.StatusForbidden {
+		t.Fatalf("expected the lookahead to be rejected, got %d", status)
+	}
+}
+
+func TestLogin_AliasSource(t *testing.T) {
+	role := "testrole"
+	subjectId := "ocid1.user.oc1..bbbbtest"
+	b, storage := setupTestLoginBackend(t, role, map[string]interface{}{"alias_source": AliasSourceSubjectId})
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     subjectId,
+		principalType: PrincipalTypeUser,
+		groupIds:      []string{"ocid1"},
+	})
+
+	headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role), nil)
+	for _, operation := range []logical.Operation{logical.AliasLookaheadOperation, logical.UpdateOperation} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      "login/" + role,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("%s failed. resp:%#v\n err:%v", operation, resp, err)
+		}
+
+		// Each principal has its own alias, which the lookahead and the login agree on
+		if resp.Auth == nil || resp.Auth.Alias == nil || resp.Auth.Alias.Name != subjectId {
+			t.Fatalf("expected the %s alias to be named after the principal, got: %#v", operation, resp.Auth)
+		}
+	}
+}
+
+func TestCredentialTypeOf(t *testing.T) {
+	signedWith := func(keyId string) http.Header {
+		headers := http.Header{}
+		headers.Set(HdrAuthorization, `Signature version="1",headers="date (request-target) host",keyId="`+keyId+`",algorithm="rsa-sha256",signature="c2lnbmF0dXJl"`)
+		return headers
+	}
+	claimsOf := func(keyValues ...string) InternalClaims {
+		claims := InternalClaims{}
+		for i := 0; i < len(keyValues); i += 2 {
+			claims[keyValues[i]] = []InternalClaim{{Key: keyValues[i], Value: keyValues[i+1], Issuer: "authService.oracle.com"}}
+		}
+		return claims
+	}
+
+	tests := map[string]struct {
+		headers        http.Header
+		claims         InternalClaims
+		credentialType string
+	}{
+		"user api key":             {signedWith("ocid1.tenancy.oc1..aaaa/ocid1.user.oc1..bbbb/aa:bb"), claimsOf(ClaimPrincipalType, PrincipalTypeUser), CredentialTypeAPIKey},
+		"user session token":       {signedWith(securityTokenKeyIdPrefix + "token"), claimsOf(ClaimPrincipalType, PrincipalTypeUser), CredentialTypeSessionToken},
+		"instance certificate":     {signedWith(securityTokenKeyIdPrefix + "token"), claimsOf(ClaimPrincipalType, PrincipalTypeInstance), CredentialTypeInstanceCertificate},
+		"resource principal token": {signedWith(securityTokenKeyIdPrefix + "token"), claimsOf(ClaimPrincipalType, PrincipalTypeResource, ClaimResourceType, "computecontainerinstance"), CredentialTypeResourcePrincipal},
+		"workload token":           {signedWith(securityTokenKeyIdPrefix + "token"), claimsOf(ClaimPrincipalType, PrincipalTypeWorkload), CredentialTypeResourcePrincipal},
+		"resource type only":       {signedWith(securityTokenKeyIdPrefix + "token"), claimsOf(ClaimResourceType, "fnfunc"), CredentialTypeResourcePrincipal},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if credentialType := credentialTypeOf(tt.headers, tt.claims); credentialType != tt.credentialType {
+				t.Fatalf("expected %s, got %s", tt.credentialType, credentialType)
+			}
+		})
+	}
+}
+
+func TestLogin_AllowedTypes(t *testing.T) {
+	role := "testrole"
+	loginPath := PathVersionBase + fmt.Sprintf(PathBaseFormat, "oci", role)
+
+	// sessionSigned replaces the API key of a signature with a security token, which the fake OCI Identity accepts
+	sessionSigned := func(headers http.Header) http.Header {
+		authorization := headers.Get(HdrAuthorization)
+		start := strings.Index(authorization, `keyId="`) + len(`keyId="`)
+		end := start + strings.Index(authorization[start:], `"`)
+		headers.Set(HdrAuthorization, authorization[:start]+securityTokenKeyIdPrefix+"token"+authorization[end:])
+		return headers
+	}
+
+	tests := []struct {
+		name          string
+		roleData      map[string]interface{}
+		principalType string
+		session       bool
+		allowed       bool
+	}{
+		{"DefaultInstance", nil, PrincipalTypeInstance, true, true},
+		{"DefaultUser", nil, PrincipalTypeUser, false, true},
+		{"DefaultResource", nil, PrincipalTypeResource, true, false},
+		{"InstancesOnlyUser", map[string]interface{}{"allowed_principal_types": "instance"}, PrincipalTypeUser, false, false},
+		{"InstancesOnlyInstance", map[string]interface{}{"allowed_principal_types": "instance"}, PrincipalTypeInstance, true, true},
+		{"Workload", map[string]interface{}{"allowed_principal_types": "workload"}, PrincipalTypeWorkload, true, true},
+		{"NoAPIKeys", map[string]interface{}{"allowed_credential_types": "session_token,instance_certificate"}, PrincipalTypeUser, false, false},
+		{"SessionToken", map[string]interface{}{"allowed_credential_types": "session_token"}, PrincipalTypeUser, true, true},
+		{"NoInstanceCertificate", map[string]interface{}{"allowed_credential_types": "api_key,session_token"}, PrincipalTypeInstance, true, false},
+		{"ResourcePrincipalToken", map[string]interface{}{"allowed_principal_types": "resource", "allowed_credential_types": "resource_principal_token"}, PrincipalTypeResource, true, true},
+		{"ResourcePrincipalNotSession", map[string]interface{}{"allowed_principal_types": "resource", "allowed_credential_types": "session_token"}, PrincipalTypeResource, true, false},
+		{"UserSessionNotResourcePrincipal", map[string]interface{}{"allowed_credential_types": "resource_principal_token"}, PrincipalTypeUser, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := setupTestLoginBackend(t, role, nil)
+			if tt.roleData != nil {
+				if err := createRole(tt.roleData, role, b, &logical.BackendConfig{StorageView: storage}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			useFakeIdentity(t, b, &fakeIdentity{
+				subjectId:     "ocid1.principal.oc1..aaaatest",
+				principalType: tt.principalType,
+				groupIds:      []string{"ocid1"},
+			})
+
+			headers := signTestLoginRequest(t, "https://vault.example.com", loginPath, nil)
+			if tt.session {
+				headers = sessionSigned(headers)
+			}
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login/" + role,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"request_headers": headers,
+				},
+			})
+			if tt.allowed {
+				if err != nil || resp == nil || resp.Auth == nil {
+					t.Fatalf("expected the login to succeed, got resp:%#v err:%v", resp, err)
+				}
+				return
+			}
+			if status, errorCode, errString := loginErrorOf(t, resp, err); status != http.StatusForbidden || errorCode != ErrorCodeAccessDenied ||
+				!strings.Contains(errString, "is not allowed for role") {
+				t.Fatalf("expected the login to be denied, got %d %q %q", status, errorCode, errString)
+			}
+		})
+	}
+
+	t.Run("SelectRole", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, role, nil)
+		if err := createRole(map[string]interface{}{"allowed_principal_types": "instance"}, role, b, &logical.BackendConfig{StorageView: storage}); err != nil {
+			t.Fatal(err)
+		}
+		useFakeIdentity(t, b, &fakeIdentity{
+			subjectId:     "ocid1.user.oc1..bbbbtest",
+			principalType: PrincipalTypeUser,
+			groupIds:      []string{"ocid1"},
+		})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathLoginFormat, "oci"), nil),
+			},
+		})
+		if status, _, errString := loginErrorOf(t, resp, err); status != http.StatusForbidden || !strings.Contains(errString, "No role allows") {
+			t.Fatalf("expected the instances only role not to be chosen, got %d %q", status, errString)
+		}
+	})
+
+	t.Run("InvalidType", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, role, nil)
+		err := createRole(map[string]interface{}{"allowed_principal_types": "instance,robot"}, role, b, &logical.BackendConfig{StorageView: storage})
+		if err == nil || !strings.Contains(err.Error(), "robot") {
+			t.Fatalf("expected the invalid principal type to be rejected, got: %v", err)
+		}
+	})
+}
+
+func TestLogin_OcidMatchMode(t *testing.T) {
+	role := "testrole"
+
+	tests := []struct {
+		name     string
+		mode     string
+		denied   string
+		groupIds []string
+		allowed  bool
+		errMsg   string
+	}{
+		{"AnyNone", "any", "", []string{"ocid9"}, false, "not a part of any"},
+		{"AnySome", "any", "", []string{"ocid2"}, true, ""},
+		{"AnyAll", "any", "", []string{"ocid1", "ocid2"}, true, ""},
+		{"AllNone", "all", "", []string{"ocid9"}, false, "not a part of all"},
+		{"AllSome", "all", "", []string{"ocid1"}, false, "not a part of all"},
+		{"AllAll", "all", "", []string{"ocid1", "ocid2"}, true, ""},
+		{"AnyDeniedNotMember", "any", "ocid3", []string{"ocid1"}, true, ""},
+		{"AnyDeniedMember", "any", "ocid3", []string{"ocid1", "ocid3"}, false, "denied OCID ocid3"},
+		{"AnyDeniedOnly", "any", "ocid3", []string{"ocid3"}, false, "denied OCID ocid3"},
+		{"AllDeniedNotMember", "all", "ocid3", []string{"ocid1", "ocid2"}, true, ""},
+		{"AllDeniedMember", "all", "ocid3", []string{"ocid1", "ocid2", "ocid3"}, false, "denied OCID ocid3"},
+		{"AllSomeDeniedMember", "all", "ocid3", []string{"ocid1", "ocid3"}, false, "denied OCID ocid3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := setupTestLoginBackend(t, role, nil)
+			roleData := map[string]interface{}{"ocid_match_mode": tt.mode}
+			if tt.denied != "" {
+				roleData["denied_ocid_list"] = tt.denied
+			}
+			if err := createRole(roleData, role, b, &logical.BackendConfig{StorageView: storage}); err != nil {
+				t.Fatal(err)
+			}
+			identity := &fakeIdentity{
+				subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+				principalType: PrincipalTypeInstance,
+				groupIds:      tt.groupIds,
+			}
+			useFakeIdentity(t, b, identity)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login/" + role,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"request_headers": signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role), nil),
+				},
+			})
+			if calls := len(identity.requests["/v1/filterGroupMembership"]); calls != 1 {
+				t.Fatalf("expected a single membership check, got %d", calls)
+			}
+			if tt.allowed {
+				if err != nil || resp == nil || resp.Auth == nil {
+					t.Fatalf("expected the login to succeed, got resp:%#v err:%v", resp, err)
+				}
+				return
+			}
+			if status, _, errString := loginErrorOf(t, resp, err); status != http.StatusForbidden || !strings.Contains(errString, tt.errMsg) {
+				t.Fatalf("expected the login to be denied with %q, got %d %q", tt.errMsg, status, errString)
+			}
+		})
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, role, nil)
+		config := &logical.BackendConfig{StorageView: storage}
+		if err := createRole(map[string]interface{}{"ocid_match_mode": "most"}, role, b, config); err == nil {
+			t.Fatal("expected an invalid ocid_match_mode to be rejected")
+		}
+		if err := createRole(map[string]interface{}{"denied_ocid_list": "ocid2"}, role, b, config); err == nil {
+			t.Fatal("expected an OCID that is both allowed and denied to be rejected")
+		}
+	})
+}
+
+func TestLogin_OcidExpression(t *testing.T) {
+	role := "testrole"
+	roleData := map[string]interface{}{
+		"ocid_list":        "",
+		"ocid_expression":  "(devs AND oncall) OR breakglass",
+		"ocid_aliases":     map[string]interface{}{"devs": "ocid1", "oncall": "ocid2", "breakglass": "ocid3"},
+		"denied_ocid_list": "ocid4",
+	}
+
+	tests := []struct {
+		name     string
+		groupIds []string
+		allowed  bool
+	}{
+		{"DevsOnCall", []string{"ocid1", "ocid2"}, true},
+		{"DevsOnly", []string{"ocid1"}, false},
+		{"BreakGlass", []string{"ocid3"}, true},
+		{"BreakGlassDenied", []string{"ocid3", "ocid4"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, storage := setupTestLoginBackend(t, role, nil)
+			if err := createRole(roleData, role, b, &logical.BackendConfig{StorageView: storage}); err != nil {
+				t.Fatal(err)
+			}
+			identity := &fakeIdentity{
+				subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+				principalType: PrincipalTypeInstance,
+				groupIds:      tt.groupIds,
+			}
+			useFakeIdentity(t, b, identity)
+
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "login/" + role,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"request_headers": signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role), nil),
+				},
+			})
+			if tt.allowed != (err == nil) {
+				t.Fatalf("expected the login to be allowed: %v, got: %v", tt.allowed, err)
+			}
+			if calls := len(identity.requests["/v1/filterGroupMembership"]); calls != 1 {
+				t.Fatalf("expected a single membership check, got %d", calls)
+			}
+			if tt.allowed != (resp != nil && resp.Auth != nil) {
+				t.Fatalf("expected the login to be allowed: %v, got: %#v", tt.allowed, resp)
+			}
+		})
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		b, storage := setupTestLoginBackend(t, role, nil)
+		config := &logical.BackendConfig{StorageView: storage}
+
+		err := createRole(map[string]interface{}{"ocid_list": "", "ocid_expression": "(ocid1 AND ocid2"}, role, b, config)
+		if err == nil || !strings.Contains(err.Error(), "invalid ocid_expression: expected ) to close the ( at position 1") {
+			t.Fatalf("expected the unbalanced expression to be rejected, got: %v", err)
+		}
+		err = createRole(map[string]interface{}{"ocid_expression": "ocid1 OR ocid3"}, role, b, config)
+		if err == nil || !strings.Contains(err.Error(), "can not both be set") {
+			t.Fatalf("expected an expression next to ocid_list to be rejected, got: %v", err)
+		}
+		err = createRole(map[string]interface{}{"ocid_list": "", "ocid_expression": "and OR ocid3", "ocid_aliases": map[string]interface{}{"and": "ocid1"}}, role, b, config)
+		if err == nil || !strings.Contains(err.Error(), "invalid alias") {
+			t.Fatalf("expected an operator as an alias to be rejected, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,439 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants store the actions taken for OCI events
+const (
+	OCIEventActionRevalidate = "revalidate"
+	OCIEventActionRevoke     = "revoke"
+	OCIEventActionIgnore     = "ignore"
+)
+
+// ociEventMaxSkew bounds the age of the signature timestamp of an OCI event
+const ociEventMaxSkew = 5 * time.Minute
+
+// defaultOCIEventActions are the actions taken for OCI event types that are not in the events_actions of the config
+var defaultOCIEventActions = map[string]string{
+	"com.oraclecloud.computeapi.terminateinstance.end":         OCIEventActionRevoke,
+	"com.oraclecloud.identitycontrolplane.deleteuser":          OCIEventActionRevoke,
+	"com.oraclecloud.identitycontrolplane.updateuserstate":     OCIEventActionRevalidate,
+	"com.oraclecloud.identitycontrolplane.removeuserfromgroup": OCIEventActionRevalidate,
+	"com.oraclecloud.identitycontrolplane.deletegroup":         OCIEventActionRevalidate,
+	"com.oraclecloud.identitycontrolplane.updatedynamicgroup":  OCIEventActionRevalidate,
+	"com.oraclecloud.identitycontrolplane.deletedynamicgroup":  OCIEventActionRevalidate,
+}
+
+func pathOCIEvents(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "events/oci$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "receive",
+			OperationSuffix: "event",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"message": {
+				Type:        framework.TypeString,
+				Description: "The OCI event, as the JSON body that OCI Events delivers to the relay posting it.",
+			},
+			"timestamp": {
+				Type:        framework.TypeInt64,
+				Description: "Time the signature was made, in seconds since the Unix epoch.",
+			},
+			"signature": {
+				Type:        framework.TypeString,
+				Description: "Base64 encoded HMAC-SHA256 of the timestamp, a period and the message, keyed with the events_signing_key of the config.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathOCIEventsUpdate,
+		},
+
+		HelpSynopsis:    pathOCIEventsSyn,
+		HelpDescription: pathOCIEventsDesc,
+	}
+}
+
+// ociEvent holds the fields of an OCI event that identify the affected resources
+type ociEvent struct {
+	EventType string `json:"eventType"`
+	EventId   string `json:"eventID"`
+	Data      struct {
+		ResourceId        string                 `json:"resourceId"`
+		AdditionalDetails map[string]interface{} `json:"additionalDetails"`
+	} `json:"data"`
+}
+
+// ocids returns the OCIDs of the resources affected by the event
+func (e *ociEvent) ocids() []string {
+	ocids := []string{}
+	if e.Data.ResourceId != "" {
+		ocids = append(ocids, e.Data.ResourceId)
+	}
+
+	// Such as the user and group of a group membership, in a stable order
+	keys := make([]string, 0, len(e.Data.AdditionalDetails))
+	for key := range e.Data.AdditionalDetails {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := e.Data.AdditionalDetails[key].(string); ok && strings.HasPrefix(value, "ocid1.") {
+			ocids = append(ocids, value)
+		}
+	}
+
+	return strutil.RemoveDuplicatesStable(ocids, false)
+}
+
+// verifyOCIEventSignature checks the signature of an OCI event made with the signing key, and that it was made
+// within ociEventMaxSkew
+func verifyOCIEventSignature(signingKey, message string, timestamp int64, signature string, now time.Time) error {
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > ociEventMaxSkew || signedAt.Sub(now) > ociEventMaxSkew {
+		return fmt.Errorf("the signature timestamp is not within %s of the current time", ociEventMaxSkew)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("the signature is not base64 encoded")
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + message))
+	if !hmac.Equal(decoded, mac.Sum(nil)) {
+		return fmt.Errorf("the signature does not match")
+	}
+	return nil
+}
+
+// ociEventAction returns the action the config takes for an OCI event type
+func (c *OCIConfigEntry) ociEventAction(eventType string) string {
+	eventType = strings.ToLower(eventType)
+	if action, ok := c.EventsActions[eventType]; ok {
+		return action
+	}
+	if action, ok := defaultOCIEventActions[eventType]; ok {
+		return action
+	}
+	return OCIEventActionIgnore
+}
+
+func (b *backend) pathOCIEventsUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if configEntry == nil || configEntry.EventsSigningKey == "" {
+		return logical.ErrorResponse("OCI events are not accepted until events_signing_key is set on the config"), nil
+	}
+
+	message := data.Get("message").(string)
+	if err := verifyOCIEventSignature(configEntry.EventsSigningKey, message, data.Get("timestamp").(int64), data.Get("signature").(string), time.Now()); err != nil {
+		b.Logger().Info("rejected OCI event", "error", err)
+		return nil, logical.ErrPermissionDenied
+	}
+
+	var event ociEvent
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		return logical.ErrorResponse("message is not a valid OCI event: %s", err), nil
+	}
+	if event.EventType == "" {
+		return logical.ErrorResponse("message is not a valid OCI event: eventType is missing"), nil
+	}
+
+	action := configEntry.ociEventAction(event.EventType)
+	ocids := event.ocids()
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"event_type": event.EventType,
+			"event_id":   event.EventId,
+			"action":     action,
+			"ocids":      ocids,
+		},
+	}
+	if action == OCIEventActionIgnore {
+		return resp, nil
+	}
+	if configEntry.TokenRevalidationInterval <= 0 {
+		resp.AddWarning("token_revalidation_interval is not set on the config, so there are no tracked tokens to act on")
+		return resp, nil
+	}
+
+	principals, roles, accessors, err := b.applyOCIEvent(ctx, req.Storage, event.EventType, action, ocids)
+	if err != nil {
+		return nil, err
+	}
+	resp.Data["principals"] = principals
+	resp.Data["roles"] = roles
+	b.Logger().Info("received OCI event", "event_type", event.EventType, "event_id", event.EventId, "action", action,
+		"principals", strings.Join(principals, ","), "roles", strings.Join(roles, ","))
+
+	if action == OCIEventActionRevoke && len(principals) > 0 {
+		if configEntry.TokenRevocationAddress == "" {
+			resp.AddWarning("token_revocation_address is not set on the config, so the tokens of the principals are only denied renewal")
+		} else {
+			revoked, revokeErrs, err := b.revokeTrackedAccessors(ctx, req, configEntry, accessors)
+			if err != nil {
+				return nil, err
+			}
+			resp.Data["revoked_accessors"] = revoked
+			for _, revokeErr := range revokeErrs {
+				resp.AddWarning(revokeErr.Error())
+			}
+		}
+	}
+
+	// Principals that are due are re-validated right away rather than on the next run of the periodic function
+	if len(principals) > 0 || len(roles) > 0 {
+		if err := b.revalidateTokens(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// applyOCIEvent takes the action for the OCIDs affected by an OCI event. Principals with tracked tokens are
+// denied renewal or made due for re-validation, and the principals of the roles that list one of the OCIDs, such
+// as a group whose members changed, are made due for re-validation. It returns the affected principals and roles,
+// and for 'revoke' the tracked accessors of each affected principal.
+func (b *backend) applyOCIEvent(ctx context.Context, s logical.Storage, eventType, action string, ocids []string) ([]string, []string, map[string][]string, error) {
+	principals := []string{}
+	accessors := map[string][]string{}
+	for _, ocid := range ocids {
+		tracked, err := b.updateTrackedPrincipal(ctx, s, ocid, func(tracked *OCITrackedTokensEntry) bool {
+			if action == OCIEventActionRevoke {
+				tracked.RenewalDenied = true
+				tracked.RenewalDeniedReason = fmt.Sprintf("OCI event %s", eventType)
+				accessors[ocid] = tracked.accessors()
+				return true
+			}
+			if tracked.RenewalDenied {
+				return false
+			}
+			tracked.LastValidated = time.Time{}
+			return true
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if tracked {
+			principals = append(principals, ocid)
+		}
+	}
+
+	roleNames, err := s.List(ctx, "role/")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	roles := []string{}
+	for _, roleName := range roleNames {
+		roleEntry, err := b.getOCIRole(ctx, s, roleName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if roleEntry == nil || !listsAnyOf(roleEntry.membershipOcids(), ocids) {
+			continue
+		}
+		if err := b.requestRevalidation(ctx, s, roleName); err != nil {
+			return nil, nil, nil, err
+		}
+		roles = append(roles, roleName)
+	}
+
+	return principals, roles, accessors, nil
+}
+
+// revokeTrackedAccessors revokes the tokens with the tracked accessors of each principal through the token store
+// of the Vault server at the token_revocation_address of the config, in the namespace of the mount, and stops
+// tracking the revoked accessors. It returns the revoked accessors, and the errors of those that were not revoked.
+// The token store is called without holding the lock, so that renewals are not blocked by the calls.
+func (b *backend) revokeTrackedAccessors(ctx context.Context, req *logical.Request, configEntry *OCIConfigEntry,
+	accessors map[string][]string) ([]string, []error, error) {
+
+	client, err := newTokenRevocationClient(configEntry, namespaceOfMountPoint(req.MountPoint))
+	if err != nil {
+		b.recordRevocation(err)
+		b.Logger().Error("unable to create the Vault client to revoke tokens", "error", err)
+		return nil, nil, err
+	}
+
+	subjectIds := make([]string, 0, len(accessors))
+	for subjectId := range accessors {
+		subjectIds = append(subjectIds, subjectId)
+	}
+	sort.Strings(subjectIds)
+
+	allRevoked := []string{}
+	revokeErrs := []error{}
+	for _, subjectId := range subjectIds {
+		revoked := []string{}
+		for _, accessor := range accessors[subjectId] {
+			if err := client.Auth().Token().RevokeAccessorWithContext(ctx, accessor); err != nil {
+				revokeErr := fmt.Errorf("unable to revoke accessor %q of principal %q: %w", accessor, subjectId, err)
+				b.recordRevocation(revokeErr)
+				b.Logger().Warn("unable to revoke a tracked token", "subject_id", subjectId, "accessor", accessor, "error", err)
+				revokeErrs = append(revokeErrs, revokeErr)
+				continue
+			}
+			b.recordRevocation(nil)
+			revoked = append(revoked, accessor)
+		}
+		if len(revoked) == 0 {
+			continue
+		}
+		allRevoked = append(allRevoked, revoked...)
+
+		if _, err := b.updateTrackedPrincipal(ctx, req.Storage, subjectId, func(tracked *OCITrackedTokensEntry) bool {
+			tracked.removeAccessors(revoked)
+			return true
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return allRevoked, revokeErrs, nil
+}
+
+// newTokenRevocationClient creates the client of the Vault API at the token_revocation_address of the config
+func newTokenRevocationClient(configEntry *OCIConfigEntry, namespace string) (*api.Client, error) {
+	clientConfig := api.DefaultConfig()
+	if clientConfig.Error != nil {
+		return nil, clientConfig.Error
+	}
+	clientConfig.Address = configEntry.TokenRevocationAddress
+	if configEntry.TokenRevocationCACert != "" || configEntry.TokenRevocationTLSServerName != "" {
+		if err := clientConfig.ConfigureTLS(&api.TLSConfig{
+			CACertBytes:   []byte(configEntry.TokenRevocationCACert),
+			TLSServerName: configEntry.TokenRevocationTLSServerName,
+		}); err != nil {
+			return nil, fmt.Errorf("unable to configure the TLS of the Vault client to revoke tokens: %w", err)
+		}
+	}
+
+	client, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the Vault client to revoke tokens: %w", err)
+	}
+	client.SetToken(configEntry.TokenRevocationToken)
+	if namespace != "" {
+		client.SetNamespace(namespace)
+	} else {
+		client.ClearNamespace()
+	}
+	return client, nil
+}
+
+// revocationStatus is the outcome of the revocations through the token_revocation_address of the config since the
+// plugin started. The plugin neither looks up nor renews the token, so a token that expired shows as the last error.
+type revocationStatus struct {
+	lastSuccess   time.Time
+	lastError     string
+	lastErrorTime time.Time
+}
+
+// recordRevocation records the outcome of a revocation through the token_revocation_address of the config
+func (b *backend) recordRevocation(err error) {
+	b.revocationMutex.Lock()
+	defer b.revocationMutex.Unlock()
+
+	now := time.Now().UTC()
+	if err != nil {
+		b.revocationStatus.lastError = err.Error()
+		b.revocationStatus.lastErrorTime = now
+		return
+	}
+	b.revocationStatus.lastSuccess = now
+}
+
+// tokenRevocationStatus returns the outcome of the revocations through the token_revocation_address of the config
+func (b *backend) tokenRevocationStatus() map[string]interface{} {
+	b.revocationMutex.Lock()
+	defer b.revocationMutex.Unlock()
+
+	status := map[string]interface{}{
+		"last_success":    "",
+		"last_error":      b.revocationStatus.lastError,
+		"last_error_time": "",
+	}
+	if !b.revocationStatus.lastSuccess.IsZero() {
+		status["last_success"] = b.revocationStatus.lastSuccess.Format(time.RFC3339)
+	}
+	if !b.revocationStatus.lastErrorTime.IsZero() {
+		status["last_error_time"] = b.revocationStatus.lastErrorTime.Format(time.RFC3339)
+	}
+	return status
+}
+
+// listsAnyOf returns whether the list contains any of the OCIDs
+func listsAnyOf(list []string, ocids []string) bool {
+	for _, ocid := range ocids {
+		if strutil.StrListContains(list, ocid) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateTrackedPrincipal applies update to the tracked tokens of a principal, and returns whether the principal has
+// tracked tokens that update changed. The tokens are left as they are if update returns false.
+func (b *backend) updateTrackedPrincipal(ctx context.Context, s logical.Storage, subjectId string, update func(*OCITrackedTokensEntry) bool) (bool, error) {
+	b.trackedTokensMutex.Lock()
+	defer b.trackedTokensMutex.Unlock()
+
+	tracked, err := b.getOCITrackedTokens(ctx, s, subjectId)
+	if err != nil {
+		return false, err
+	}
+	if tracked == nil || !update(tracked) {
+		return false, nil
+	}
+
+	return true, b.setOCITrackedTokens(ctx, s, tracked)
+}
+
+const pathOCIEventsSyn = `
+Receives OCI events that affect the principals with tokens.
+`
+
+const pathOCIEventsDesc = `
+Accepts the JSON body of an OCI event together with a timestamp and an
+HMAC-SHA256 signature keyed with the events_signing_key of the config. OCI
+Notifications can not sign its deliveries or send a Vault token, so the events
+are posted by a relay, such as an OCI Function subscribed to the topic, that
+signs the body it receives. Events are rejected until events_signing_key is
+set, or if the signature was made more than 5 minutes from the current time.
+
+The action taken for the event type comes from the events_actions of the
+config, or else from the defaults: instance termination and user deletion
+revoke the tracked tokens of the affected principal, user state changes
+re-validate the affected principal, since they include a user being
+unblocked, and changes to groups and dynamic groups re-validate the
+principals of the roles that list the group. 'revalidate' checks the group membership of a principal
+with tracked tokens again right away. 'revoke' denies the renewal of its
+tokens, and if token_revocation_address is set on the config, revokes the
+tokens with its tracked accessors through the token store. Accessors are only
+tracked once a token is renewed, so tokens that were not renewed yet expire at
+the end of their current TTL. Other event types are ignored.
+`
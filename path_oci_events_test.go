@@ -0,0 +1,277 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func signTestOCIEvent(signingKey, message string, timestamp time.Time) map[string]interface{} {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "." + message))
+	return map[string]interface{}{
+		"message":   message,
+		"timestamp": timestamp.Unix(),
+		"signature": base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+// fakeTokenStore records the accessors revoked through auth/token/revoke-accessor
+type fakeTokenStore struct {
+	token   string
+	revoked []string
+}
+
+func (f *fakeTokenStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/auth/token/revoke-accessor" || r.Header.Get("X-Vault-Token") != f.token {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	var body struct {
+		Accessor string `json:"accessor"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.revoked = append(f.revoked, body.Accessor)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestBackend_OCIEvents(t *testing.T) {
+	subjectId := "ocid1.instance.oc1.phx.aaaatest"
+	tokenStore := &fakeTokenStore{token: "revocation-token"}
+	tokenStoreServer := httptest.NewTLSServer(tokenStore)
+	defer tokenStoreServer.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokenStoreServer.Certificate().Raw})
+
+	configData := map[string]interface{}{
+		"token_revalidation_interval":      60,
+		"events_signing_key":               "secret",
+		"token_revocation_address":         tokenStoreServer.URL,
+		"token_revocation_token":           tokenStore.token,
+		"token_revocation_ca_cert":         string(caCert),
+		"token_revocation_tls_server_name": "example.com",
+	}
+	b, storage := setupTestLoginBackend(t, "devrole", configData)
+	identity := &fakeIdentity{
+		subjectId:     subjectId,
+		principalType: PrincipalTypeInstance,
+		groupIds:      []string{"ocid1"},
+	}
+	useFakeIdentity(t, b, identity)
+
+	login := func(t *testing.T) *logical.Auth {
+		headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "devrole"), nil)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/devrole",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil || resp == nil || resp.Auth == nil {
+			t.Fatalf("Login failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp.Auth
+	}
+
+	renew := func(t *testing.T, auth *logical.Auth, accessor string) {
+		auth.Accessor = accessor
+		auth.TokenPolicies = auth.Policies
+		if _, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "login",
+			Storage:   storage,
+			Auth:      auth,
+		}); err != nil {
+			t.Fatalf("Renewal failed: %v", err)
+		}
+	}
+
+	postEvent := func(data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "events/oci",
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	readTokens := func(t *testing.T) map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "tokens/" + subjectId,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("Tokens read failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp.Data
+	}
+
+	terminated := `{"eventType":"com.oraclecloud.computeapi.terminateinstance.end","eventID":"event1","data":{"resourceId":"` + subjectId + `"}}`
+
+	t.Run("Signature", func(t *testing.T) {
+		login(t)
+		data := signTestOCIEvent("wrong", terminated, time.Now())
+		if _, err := postEvent(data); err != logical.ErrPermissionDenied {
+			t.Fatalf("expected an event with a bad signature to be denied, got: %v", err)
+		}
+		data = signTestOCIEvent("secret", terminated, time.Now().Add(-10*time.Minute))
+		if _, err := postEvent(data); err != logical.ErrPermissionDenied {
+			t.Fatalf("expected an event with a stale signature to be denied, got: %v", err)
+		}
+		data = signTestOCIEvent("secret", terminated, time.Now())
+		data["message"] = strings.Replace(terminated, "event1", "event2", 1)
+		if _, err := postEvent(data); err != logical.ErrPermissionDenied {
+			t.Fatalf("expected a modified event to be denied, got: %v", err)
+		}
+		if readTokens(t)["renewal_denied"] != false {
+			t.Fatal("expected the denied events to have no effect")
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		renew(t, login(t), "accessor1")
+		resp, err := postEvent(signTestOCIEvent("secret", terminated, time.Now()))
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("Event failed. resp:%#v\n err:%v", resp, err)
+		}
+		if resp.Data["action"] != OCIEventActionRevoke || !reflect.DeepEqual(resp.Data["principals"], []string{subjectId}) {
+			t.Fatalf("unexpected result: %#v", resp.Data)
+		}
+		if !reflect.DeepEqual(resp.Data["revoked_accessors"], []string{"accessor1"}) || !reflect.DeepEqual(tokenStore.revoked, []string{"accessor1"}) {
+			t.Fatalf("expected the tracked accessor to be revoked, got: %#v, token store: %v", resp.Data, tokenStore.revoked)
+		}
+		tokens := readTokens(t)
+		if tokens["renewal_denied"] != true || !strings.Contains(tokens["renewal_denied_reason"].(string), "terminateinstance") {
+			t.Fatalf("expected the renewal of the tokens of the terminated instance to be denied, got: %#v", tokens)
+		}
+		if accessors := tokens["accessors"].([]string); len(accessors) != 0 {
+			t.Fatalf("expected the revoked accessor to no longer be tracked, got: %v", accessors)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "config",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("Config read failed. resp:%#v\n err:%v", resp, err)
+		}
+		status := resp.Data["token_revocation_status"].(map[string]interface{})
+		if status["last_success"] == "" || status["last_error"] != "" {
+			t.Fatalf("expected the revocation to be reported as successful, got: %#v", status)
+		}
+	})
+
+	t.Run("Revalidate", func(t *testing.T) {
+		renew(t, login(t), "accessor3")
+		identity.groupIds = []string{"ocid9"}
+		defer func() { identity.groupIds = []string{"ocid1"} }()
+
+		removed := `{"eventType":"com.oraclecloud.identitycontrolplane.removeuserfromgroup","data":{"resourceId":"ocid1","additionalDetails":{"userId":"ocid1.user.oc1..aaaaother"}}}`
+		resp, err := postEvent(signTestOCIEvent("secret", removed, time.Now()))
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("Event failed. resp:%#v\n err:%v", resp, err)
+		}
+		if !reflect.DeepEqual(resp.Data["roles"], []string{"devrole"}) {
+			t.Fatalf("expected the principals of devrole to be re-validated, got: %#v", resp.Data)
+		}
+		tokens := readTokens(t)["roles"].(map[string]interface{})["devrole"].(map[string]interface{})
+		if tokens["renewal_denied"] != true || !strings.Contains(tokens["renewal_denied_reason"].(string), "devrole") {
+			t.Fatalf("expected the principal to be re-validated right away, got: %#v", tokens)
+		}
+		if !reflect.DeepEqual(tokenStore.revoked, []string{"accessor1", "accessor3"}) {
+			t.Fatalf("expected the tokens denied renewal to be revoked, token store: %v", tokenStore.revoked)
+		}
+	})
+
+	t.Run("Actions", func(t *testing.T) {
+		configData["events_actions"] = map[string]interface{}{"com.oraclecloud.computeapi.terminateinstance.end": "ignore"}
+		writeConfig := func(data map[string]interface{}) (*logical.Response, error) {
+			data[HomeTenancyIdConfigName] = "ocid1.tenancy.oc1..aaaatest"
+			return b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "config",
+				Storage:   storage,
+				Data:      data,
+			})
+		}
+		if resp, err := writeConfig(configData); err != nil || resp.IsError() {
+			t.Fatalf("Config write failed. resp:%#v\n err:%v", resp, err)
+		}
+		useFakeIdentity(t, b, identity)
+
+		login(t)
+		resp, err := postEvent(signTestOCIEvent("secret", terminated, time.Now()))
+		if err != nil || resp == nil || resp.Data["action"] != OCIEventActionIgnore {
+			t.Fatalf("expected the event to be ignored. resp:%#v\n err:%v", resp, err)
+		}
+		if readTokens(t)["renewal_denied"] != false {
+			t.Fatal("expected an ignored event to have no effect")
+		}
+
+		// A user state change is re-validated by default, since it is also sent when a user is unblocked
+		updated := `{"eventType":"com.oraclecloud.identitycontrolplane.updateuserstate","data":{"resourceId":"` + subjectId + `"}}`
+		resp, err = postEvent(signTestOCIEvent("secret", updated, time.Now()))
+		if err != nil || resp == nil || resp.Data["action"] != OCIEventActionRevalidate {
+			t.Fatalf("expected the event to be re-validated. resp:%#v\n err:%v", resp, err)
+		}
+
+		resp, err = writeConfig(map[string]interface{}{"events_actions": map[string]interface{}{"com.oraclecloud.computeapi.terminateinstance.end": "delete"}})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an invalid action to be rejected. resp:%#v\n err:%v", resp, err)
+		}
+		resp, err = writeConfig(map[string]interface{}{
+			"token_revocation_address": tokenStoreServer.URL,
+			"token_revocation_token":   tokenStore.token,
+			"token_revocation_ca_cert": "not a certificate",
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("expected an invalid CA certificate to be rejected. resp:%#v\n err:%v", resp, err)
+		}
+	})
+
+	t.Run("RevokeWithoutRevocationAddress", func(t *testing.T) {
+		delete(configData, "events_actions")
+		delete(configData, "token_revocation_address")
+		delete(configData, "token_revocation_token")
+		configData[HomeTenancyIdConfigName] = "ocid1.tenancy.oc1..aaaatest"
+		if resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      configData,
+		}); err != nil || resp.IsError() {
+			t.Fatalf("Config write failed. resp:%#v\n err:%v", resp, err)
+		}
+		useFakeIdentity(t, b, identity)
+		tokenStore.revoked = nil
+
+		renew(t, login(t), "accessor2")
+		resp, err := postEvent(signTestOCIEvent("secret", terminated, time.Now()))
+		if err != nil || resp == nil || resp.IsError() || len(resp.Warnings) != 1 {
+			t.Fatalf("expected a warning that the tokens are only denied renewal. resp:%#v\n err:%v", resp, err)
+		}
+		if len(tokenStore.revoked) != 0 || readTokens(t)["renewal_denied"] != true {
+			t.Fatalf("expected the renewal of the tokens to be denied without revoking them, token store: %v", tokenStore.revoked)
+		}
+	})
+}
@@ -0,0 +1,448 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants store the storage prefixes and bounds of the login ledger
+const (
+	ledgerStoragePrefix          = "ledger/"
+	ledgerPrincipalStoragePrefix = ledgerStoragePrefix + "principal/"
+	ledgerRoleStoragePrefix      = ledgerStoragePrefix + "role/"
+
+	// Index of the principals in the ledger that logged in with each role, keyed on the role and the principal
+	ledgerRolePrincipalsStoragePrefix = ledgerStoragePrefix + "role-principals/"
+
+	// Principals that have not logged in for this long are dropped from the ledger
+	ledgerRetention = 90 * 24 * time.Hour
+
+	// Beyond this number of principals, those that logged in least recently are dropped from the ledger
+	ledgerMaxPrincipals = 10000
+
+	// Interval at which the ledger is pruned
+	ledgerPruneInterval = time.Hour
+)
+
+func pathPrincipals(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "principals/" + framework.GenericNameRegex("subject_id"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationSuffix: "principal",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"subject_id": {
+				Type:        framework.TypeString,
+				Description: "OCID of the user or instance principal.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathPrincipalRead,
+		},
+
+		HelpSynopsis:    pathPrincipalsSyn,
+		HelpDescription: pathPrincipalsDesc,
+	}
+}
+
+func pathListPrincipals(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "principals/?",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "list",
+			OperationSuffix: "principals",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "If set, only the principals that logged in with this role are listed.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathPrincipalList,
+		},
+
+		HelpSynopsis:    pathListPrincipalsSyn,
+		HelpDescription: pathListPrincipalsDesc,
+	}
+}
+
+func pathRolesUsage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/usage$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "read",
+			OperationSuffix: "roles-usage",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRolesUsageRead,
+		},
+
+		HelpSynopsis:    pathRolesUsageSyn,
+		HelpDescription: pathRolesUsageDesc,
+	}
+}
+
+func ledgerPrincipalKey(subjectId string) string {
+	return ledgerPrincipalStoragePrefix + strings.ToLower(subjectId)
+}
+
+func ledgerRolePrincipalsPrefix(roleName string) string {
+	return ledgerRolePrincipalsStoragePrefix + roleName + "/"
+}
+
+// getOCIPrincipalLogins returns the ledger entry of a principal
+func (b *backend) getOCIPrincipalLogins(ctx context.Context, s logical.Storage, subjectId string) (*OCIPrincipalLoginsEntry, error) {
+	entry, err := s.Get(ctx, ledgerPrincipalKey(subjectId))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result OCIPrincipalLoginsEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// getOCIRoleUsage returns the usage counters of a role
+func (b *backend) getOCIRoleUsage(ctx context.Context, s logical.Storage, roleName string) (*OCIRoleUsageEntry, error) {
+	entry, err := s.Get(ctx, ledgerRoleStoragePrefix+roleName)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result OCIRoleUsageEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// recordLogin adds a successful login of a principal with the given roles to the ledger
+func (b *backend) recordLogin(ctx context.Context, s logical.Storage, subjectId string, roleNames []string, sourceIP string) error {
+	if subjectId == "" {
+		return nil
+	}
+
+	b.ledgerMutex.Lock()
+	defer b.ledgerMutex.Unlock()
+
+	now := time.Now().UTC()
+
+	principal, err := b.getOCIPrincipalLogins(ctx, s, subjectId)
+	if err != nil {
+		return err
+	}
+	if principal == nil {
+		principal = &OCIPrincipalLoginsEntry{
+			SubjectId: subjectId,
+			Roles:     map[string]*OCIRoleLoginsEntry{},
+		}
+	}
+	principal.LastLogin = now
+	principal.LastRole = strings.Join(roleNames, ",")
+	principal.LastSourceIP = sourceIP
+	principal.LoginCount++
+
+	for _, roleName := range roleNames {
+		roleLogins, ok := principal.Roles[roleName]
+		if !ok {
+			roleLogins = &OCIRoleLoginsEntry{}
+			principal.Roles[roleName] = roleLogins
+
+			if err := s.Put(ctx, &logical.StorageEntry{Key: ledgerRolePrincipalsPrefix(roleName) + strings.ToLower(subjectId)}); err != nil {
+				return err
+			}
+		}
+		roleLogins.LastLogin = now
+		roleLogins.LoginCount++
+
+		usage, err := b.getOCIRoleUsage(ctx, s, roleName)
+		if err != nil {
+			return err
+		}
+		if usage == nil {
+			usage = &OCIRoleUsageEntry{}
+		}
+		usage.LastLogin = now
+		usage.LoginCount++
+
+		entry, err := logical.StorageEntryJSON(ledgerRoleStoragePrefix+roleName, usage)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(ledgerPrincipalKey(subjectId), principal)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// deletePrincipalLogins drops a principal from the ledger and from the index of the roles it logged in with
+func (b *backend) deletePrincipalLogins(ctx context.Context, s logical.Storage, key string, principal *OCIPrincipalLoginsEntry) error {
+	for roleName := range principal.Roles {
+		if err := s.Delete(ctx, ledgerRolePrincipalsPrefix(roleName)+key); err != nil {
+			return err
+		}
+	}
+	return s.Delete(ctx, ledgerPrincipalStoragePrefix+key)
+}
+
+// deleteRoleLogins drops a role from the ledger, so that a role re-created with the same name does not inherit
+// the principals that logged in with it. It returns the number of principals that had logged in with the role.
+func (b *backend) deleteRoleLogins(ctx context.Context, s logical.Storage, roleName string) (int, error) {
+	b.ledgerMutex.Lock()
+	defer b.ledgerMutex.Unlock()
+
+	keys, err := s.List(ctx, ledgerRolePrincipalsPrefix(roleName))
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		principal, err := b.getOCIPrincipalLogins(ctx, s, key)
+		if err != nil {
+			return 0, err
+		}
+		if principal != nil {
+			delete(principal.Roles, roleName)
+			entry, err := logical.StorageEntryJSON(ledgerPrincipalStoragePrefix+key, principal)
+			if err != nil {
+				return 0, err
+			}
+			if err := s.Put(ctx, entry); err != nil {
+				return 0, err
+			}
+		}
+		if err := s.Delete(ctx, ledgerRolePrincipalsPrefix(roleName)+key); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(keys), s.Delete(ctx, ledgerRoleStoragePrefix+roleName)
+}
+
+// pruneLedger drops the principals that have not logged in within the retention of the ledger, and those that
+// logged in least recently once the ledger holds more than its maximum number of principals
+func (b *backend) pruneLedger(ctx context.Context, s logical.Storage) error {
+	b.ledgerMutex.Lock()
+	defer b.ledgerMutex.Unlock()
+
+	keys, err := s.List(ctx, ledgerPrincipalStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	type principalLastLogin struct {
+		key       string
+		principal *OCIPrincipalLoginsEntry
+	}
+	retained := make([]principalLastLogin, 0, len(keys))
+	cutoff := time.Now().Add(-ledgerRetention)
+	for _, key := range keys {
+		principal, err := b.getOCIPrincipalLogins(ctx, s, key)
+		if err != nil {
+			return err
+		}
+		if principal == nil {
+			continue
+		}
+		if principal.LastLogin.Before(cutoff) {
+			if err := b.deletePrincipalLogins(ctx, s, key, principal); err != nil {
+				return err
+			}
+			continue
+		}
+		retained = append(retained, principalLastLogin{key, principal})
+	}
+
+	if len(retained) <= ledgerMaxPrincipals {
+		return nil
+	}
+	sort.Slice(retained, func(i, j int) bool {
+		return retained[i].principal.LastLogin.After(retained[j].principal.LastLogin)
+	})
+	for _, principal := range retained[ledgerMaxPrincipals:] {
+		if err := b.deletePrincipalLogins(ctx, s, principal.key, principal.principal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// principalsOfRole returns the subject OCIDs of the principals in the ledger that logged in with a role
+func (b *backend) principalsOfRole(ctx context.Context, s logical.Storage, roleName string) ([]string, error) {
+	return s.List(ctx, ledgerRolePrincipalsPrefix(roleName))
+}
+
+func (b *backend) pathPrincipalRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	principal, err := b.getOCIPrincipalLogins(ctx, req.Storage, data.Get("subject_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if principal == nil {
+		return nil, nil
+	}
+
+	roles := make(map[string]interface{}, len(principal.Roles))
+	for roleName, roleLogins := range principal.Roles {
+		roles[roleName] = map[string]interface{}{
+			"last_login":  roleLogins.LastLogin.Format(time.RFC3339),
+			"login_count": roleLogins.LoginCount,
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"subject_id":     principal.SubjectId,
+			"last_login":     principal.LastLogin.Format(time.RFC3339),
+			"last_role":      principal.LastRole,
+			"last_source_ip": principal.LastSourceIP,
+			"login_count":    principal.LoginCount,
+			"roles":          roles,
+		},
+	}, nil
+}
+
+func (b *backend) pathPrincipalList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if roleName := data.Get("role").(string); roleName != "" {
+		principals, err := b.principalsOfRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		return logical.ListResponse(principals), nil
+	}
+
+	entries, err := req.Storage.List(ctx, ledgerPrincipalStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathRolesUsageRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleNames, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]interface{}, len(roleNames))
+	unusedRoles := []string{}
+	for _, roleName := range roleNames {
+		usage, err := b.getOCIRoleUsage(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		principals, err := b.principalsOfRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+
+		roleUsage := map[string]interface{}{
+			"login_count":     0,
+			"last_login":      "",
+			"principal_count": len(principals),
+		}
+		if usage != nil {
+			roleUsage["login_count"] = usage.LoginCount
+			roleUsage["last_login"] = usage.LastLogin.Format(time.RFC3339)
+		}
+		if usage == nil || usage.LastLogin.Before(time.Now().Add(-ledgerRetention)) {
+			unusedRoles = append(unusedRoles, roleName)
+		}
+		roles[roleName] = roleUsage
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"roles":        roles,
+			"unused_roles": unusedRoles,
+		},
+	}, nil
+}
+
+// Struct to hold the recent logins of a principal
+type OCIPrincipalLoginsEntry struct {
+	SubjectId    string    `json:"subject_id"`
+	LastLogin    time.Time `json:"last_login"`
+	LastRole     string    `json:"last_role"`
+	LastSourceIP string    `json:"last_source_ip"`
+	LoginCount   int       `json:"login_count"`
+
+	// Logins of the principal with each role
+	Roles map[string]*OCIRoleLoginsEntry `json:"roles"`
+}
+
+// Struct to hold the logins of a principal with a role
+type OCIRoleLoginsEntry struct {
+	LastLogin  time.Time `json:"last_login"`
+	LoginCount int       `json:"login_count"`
+}
+
+// Struct to hold the usage counters of a role
+type OCIRoleUsageEntry struct {
+	LastLogin  time.Time `json:"last_login"`
+	LoginCount int       `json:"login_count"`
+}
+
+const pathPrincipalsSyn = `
+Reads the recent logins of a principal.
+`
+
+const pathPrincipalsDesc = `
+Successful logins are recorded in a ledger kept in local storage, keyed on the
+subject OCID of the principal. Reading returns the time, role and source IP of
+the last login of the principal, its number of logins, and its logins with
+each role. Principals that have not logged in for 90 days are dropped from the
+ledger.
+`
+
+const pathListPrincipalsSyn = `
+Lists the principals in the login ledger.
+`
+
+const pathListPrincipalsDesc = `
+Principals will be listed by their subject OCIDs. If role is set, only the
+principals that logged in with the role are listed, which shows what still
+depends on a role before deleting it.
+`
+
+const pathRolesUsageSyn = `
+Reports the usage of each role.
+`
+
+const pathRolesUsageDesc = `
+Reports the number of logins and the last login of each role, and the number
+of principals in the login ledger that logged in with it. Roles without a
+login in the last 90 days are reported in unused_roles.
+`
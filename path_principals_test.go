@@ -0,0 +1,154 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_LoginLedger(t *testing.T) {
+	b, storage := setupTestLoginBackend(t, "devrole", nil)
+	if err := createRole(map[string]interface{}{"ocid_list": "ocid3"}, "opsrole", b, &logical.BackendConfig{StorageView: storage}); err != nil {
+		t.Fatal(err)
+	}
+	useFakeIdentity(t, b, &fakeIdentity{
+		subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+		principalType: PrincipalTypeInstance,
+		groupIds:      []string{"ocid1"},
+	})
+
+	for i := 0; i < 2; i++ {
+		headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "devrole"), nil)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login/devrole",
+			Storage:    storage,
+			Connection: &logical.Connection{RemoteAddr: "10.0.0.1"},
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil || resp == nil || resp.Auth == nil {
+			t.Fatalf("Login failed. resp:%#v\n err:%v", resp, err)
+		}
+	}
+
+	request := func(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Request to %s failed. resp:%#v\n err:%v", path, resp, err)
+		}
+		return resp
+	}
+
+	t.Run("Principal", func(t *testing.T) {
+		resp := request(logical.ReadOperation, "principals/ocid1.instance.oc1.phx.aaaatest", nil)
+		if resp.Data["login_count"] != 2 || resp.Data["last_role"] != "devrole" || resp.Data["last_source_ip"] != "10.0.0.1" {
+			t.Fatalf("unexpected principal: %#v", resp.Data)
+		}
+		roles := resp.Data["roles"].(map[string]interface{})
+		if devrole, ok := roles["devrole"].(map[string]interface{}); !ok || devrole["login_count"] != 2 {
+			t.Fatalf("unexpected logins per role: %#v", roles)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		expected := []string{"ocid1.instance.oc1.phx.aaaatest"}
+		if keys := request(logical.ListOperation, "principals/", nil).Data["keys"]; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("unexpected principals: %v", keys)
+		}
+		if keys := request(logical.ListOperation, "principals/", map[string]interface{}{"role": "devrole"}).Data["keys"]; !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("unexpected principals of devrole: %v", keys)
+		}
+		if keys := request(logical.ListOperation, "principals/", map[string]interface{}{"role": "opsrole"}).Data["keys"]; keys != nil && len(keys.([]string)) != 0 {
+			t.Fatalf("expected no principals of opsrole, got: %v", keys)
+		}
+	})
+
+	t.Run("RolesUsage", func(t *testing.T) {
+		resp := request(logical.ReadOperation, "roles/usage", nil)
+		devrole := resp.Data["roles"].(map[string]interface{})["devrole"].(map[string]interface{})
+		if devrole["login_count"] != 2 || devrole["principal_count"] != 1 {
+			t.Fatalf("unexpected usage of devrole: %#v", devrole)
+		}
+		if unused := resp.Data["unused_roles"]; !reflect.DeepEqual(unused, []string{"opsrole"}) {
+			t.Fatalf("expected opsrole to be unused, got: %v", unused)
+		}
+	})
+
+	t.Run("DeleteRole", func(t *testing.T) {
+		if resp := request(logical.DeleteOperation, "role/opsrole", nil); resp != nil && len(resp.Warnings) != 0 {
+			t.Fatalf("expected no warning for an unused role, got: %v", resp.Warnings)
+		}
+
+		resp := request(logical.DeleteOperation, "role/devrole", nil)
+		if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "with 2 logins by 1 principals") {
+			t.Fatalf("expected a warning with the usage of a role in use, got: %#v", resp)
+		}
+		if role, err := b.getOCIRole(context.Background(), storage, "devrole"); err != nil || role != nil {
+			t.Fatalf("expected the role to be deleted, got: %#v %v", role, err)
+		}
+
+		// A role re-created with the same name does not inherit the principals of the deleted role
+		if err := createRole(map[string]interface{}{"ocid_list": "ocid1"}, "devrole", b, &logical.BackendConfig{StorageView: storage}); err != nil {
+			t.Fatal(err)
+		}
+		if keys := request(logical.ListOperation, "principals/", map[string]interface{}{"role": "devrole"}).Data["keys"]; keys != nil && len(keys.([]string)) != 0 {
+			t.Fatalf("expected no principals of the re-created devrole, got: %v", keys)
+		}
+		devrole := request(logical.ReadOperation, "roles/usage", nil).Data["roles"].(map[string]interface{})["devrole"].(map[string]interface{})
+		if devrole["login_count"] != 0 || devrole["principal_count"] != 0 {
+			t.Fatalf("unexpected usage of the re-created devrole: %#v", devrole)
+		}
+		roles := request(logical.ReadOperation, "principals/ocid1.instance.oc1.phx.aaaatest", nil).Data["roles"].(map[string]interface{})
+		if _, ok := roles["devrole"]; ok {
+			t.Fatalf("expected the deleted role to be dropped from the principal, got: %#v", roles)
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		stale, err := logical.StorageEntryJSON(ledgerPrincipalKey("ocid1.instance.oc1.phx.stale"), &OCIPrincipalLoginsEntry{
+			SubjectId: "ocid1.instance.oc1.phx.stale",
+			LastLogin: time.Now().Add(-ledgerRetention - time.Hour),
+			Roles:     map[string]*OCIRoleLoginsEntry{"stalerole": {}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(context.Background(), stale); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(context.Background(), &logical.StorageEntry{Key: ledgerRolePrincipalsPrefix("stalerole") + "ocid1.instance.oc1.phx.stale"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.pruneLedger(context.Background(), storage); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := storage.List(context.Background(), ledgerPrincipalStoragePrefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(keys, []string{"ocid1.instance.oc1.phx.aaaatest"}) {
+			t.Fatalf("expected the stale principal to be pruned, got: %v", keys)
+		}
+		principals, err := b.principalsOfRole(context.Background(), storage, "stalerole")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(principals) != 0 {
+			t.Fatalf("expected the stale principal to be dropped from the role index, got: %v", principals)
+		}
+	})
+}
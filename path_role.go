@@ -4,6 +4,11 @@ package ociauth
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/tokenutil"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -16,6 +21,12 @@ const (
 	MaxOCIDsPerRole = 100
 )
 
+// These constants store how the OCIDs of a role must match the group membership of a principal
+const (
+	OcidMatchModeAny = "any"
+	OcidMatchModeAll = "all"
+)
+
 func pathRole(b *backend) *framework.Path {
 	p := &framework.Path{
 		Pattern: "role/" + framework.GenericNameRegex("role"),
@@ -34,6 +45,44 @@ func pathRole(b *backend) *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: `A comma separated list of Group or Dynamic Group OCIDs that are allowed to take this role.`,
 			},
+			"ocid_match_mode": {
+				Type:        framework.TypeString,
+				Description: "Whether principals must be a member of 'any' (default) or 'all' of the OCIDs in ocid_list to take this role.",
+				Default:     OcidMatchModeAny,
+			},
+			"ocid_expression": {
+				Type:        framework.TypeString,
+				Description: "Boolean expression over Group or Dynamic Group OCIDs, or their aliases, that principals must satisfy to take this role, such as '(devs AND oncall) OR breakglass'. Supports AND, OR, NOT and parentheses. Can not be set together with ocid_list.",
+			},
+			"ocid_aliases": {
+				Type:        framework.TypeKVPairs,
+				Description: "Map of aliases to the Group or Dynamic Group OCIDs they stand for in ocid_expression.",
+			},
+			"denied_ocid_list": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "A comma separated list of Group or Dynamic Group OCIDs whose members may not take this role, even if they qualify through ocid_list or ocid_expression.",
+			},
+			"allowed_principal_types": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of the types of principal that may take this role: 'instance', 'user', 'resource' and 'workload'. If empty, instances and users may take the role.",
+			},
+			"allowed_credential_types": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Comma separated list of the types of credential that logins with this role may be signed with: 'api_key', 'session_token', 'instance_certificate' and 'resource_principal_token'. If empty, any credential is accepted.",
+			},
+			"login_rate_limit": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of logins per minute by a single principal with this role. If 0, the logins are not limited.",
+			},
+			"lockout_threshold": {
+				Type:        framework.TypeInt,
+				Description: "Number of consecutive failed logins by a single principal after which it is locked out of this role. If 0, principals are not locked out.",
+			},
+			"lockout_duration": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration for which a principal is locked out of this role.",
+				Default:     300,
+			},
 		},
 
 		ExistenceCheck: b.pathRoleExistenceCheck,
@@ -115,7 +164,7 @@ func (b *backend) getOCIRole(ctx context.Context, s logical.Storage, roleName st
 		return nil, fmt.Errorf("missing role name")
 	}
 
-	entry, err := s.Get(ctx, "role/"+roleName)
+	entry, err := readStorage(ctx, s, "role/"+roleName)
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +183,30 @@ func (b *backend) getOCIRole(ctx context.Context, s logical.Storage, roleName st
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("role").(string)
 
-	return nil, req.Storage.Delete(ctx, "role/"+roleName)
+	// Warn about principals that still log in with the role
+	usage, err := b.getOCIRoleUsage(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.Storage.Delete(ctx, "role/"+roleName); err != nil {
+		return nil, err
+	}
+	principalCount, err := b.deleteRoleLogins(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.requestRevalidation(ctx, req.Storage, roleName); err != nil {
+		return nil, err
+	}
+
+	if usage == nil || usage.LastLogin.Before(time.Now().Add(-ledgerRetention)) {
+		return nil, nil
+	}
+	resp := &logical.Response{}
+	resp.AddWarning(fmt.Sprintf("role %q was last used for a login at %s, with %d logins by %d principals",
+		roleName, usage.LastLogin.Format(time.RFC3339), usage.LoginCount, principalCount))
+	return resp, nil
 }
 
 func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -155,7 +227,16 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 	}
 
 	responseData := map[string]interface{}{
-		"ocid_list": append([]string{}, roleEntry.OcidList...),
+		"ocid_list":                append([]string{}, roleEntry.OcidList...),
+		"ocid_match_mode":          roleEntry.ocidMatchMode(),
+		"ocid_expression":          roleEntry.OcidExpression,
+		"ocid_aliases":             roleEntry.OcidAliases,
+		"denied_ocid_list":         append([]string{}, roleEntry.DeniedOcidList...),
+		"allowed_principal_types":  roleEntry.allowedPrincipalTypes(),
+		"allowed_credential_types": roleEntry.allowedCredentialTypes(),
+		"login_rate_limit":         roleEntry.LoginRateLimit,
+		"lockout_threshold":        roleEntry.LockoutThreshold,
+		"lockout_duration":         int64(roleEntry.LockoutDuration.Seconds()),
 	}
 
 	roleEntry.PopulateTokenData(responseData)
@@ -181,13 +262,90 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		return logical.ErrorResponse("The specified role does not exist"), nil
 	}
 
+	previousEntry := *roleEntry
 	if ocidList, ok := data.GetOk("ocid_list"); ok {
 		roleEntry.OcidList = ocidList.([]string)
-		if len(roleEntry.OcidList) > MaxOCIDsPerRole {
-			return logical.ErrorResponse("Number of OCIDs for this role exceeds the limit"), nil
+	}
+	if deniedOcidList, ok := data.GetOk("denied_ocid_list"); ok {
+		roleEntry.DeniedOcidList = deniedOcidList.([]string)
+	}
+	if ocidExpression, ok := data.GetOk("ocid_expression"); ok {
+		roleEntry.OcidExpression = strings.TrimSpace(ocidExpression.(string))
+	}
+	if ocidAliases, ok := data.GetOk("ocid_aliases"); ok {
+		roleEntry.OcidAliases = nil
+		for alias, ocid := range ocidAliases.(map[string]string) {
+			alias, ocid = strings.TrimSpace(alias), strings.TrimSpace(ocid)
+			if alias == "" || ocid == "" || strings.ContainsAny(alias, "() \t") || isOcidExpressionOperator(alias) {
+				return logical.ErrorResponse("ocid_aliases contains an invalid alias %q of %q", alias, ocid), nil
+			}
+			if roleEntry.OcidAliases == nil {
+				roleEntry.OcidAliases = map[string]string{}
+			}
+			roleEntry.OcidAliases[alias] = ocid
+		}
+	}
+	if roleEntry.OcidExpression != "" && len(roleEntry.OcidList) > 0 {
+		return logical.ErrorResponse("ocid_list and ocid_expression can not both be set"), nil
+	}
+	expression, err := roleEntry.expression()
+	if err != nil {
+		return logical.ErrorResponse("invalid ocid_expression: %s", err), nil
+	}
+
+	// The OCIDs and the denied OCIDs are checked in a single call to OCI Identity
+	if expression != nil && len(expression.ocids())+len(roleEntry.DeniedOcidList) > MaxOCIDsPerRole ||
+		len(roleEntry.OcidList)+len(roleEntry.DeniedOcidList) > MaxOCIDsPerRole {
+		return logical.ErrorResponse("Number of OCIDs for this role exceeds the limit"), nil
+	}
+	for _, ocid := range roleEntry.DeniedOcidList {
+		if strutil.StrListContains(roleEntry.OcidList, ocid) {
+			return logical.ErrorResponse("OCID %q is in both ocid_list and denied_ocid_list", ocid), nil
 		}
 	}
 
+	if ocidMatchMode, ok := data.GetOk("ocid_match_mode"); ok {
+		roleEntry.OcidMatchMode = ocidMatchMode.(string)
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.OcidMatchMode = data.Get("ocid_match_mode").(string)
+	}
+	switch roleEntry.OcidMatchMode {
+	case "", OcidMatchModeAny, OcidMatchModeAll:
+	default:
+		return logical.ErrorResponse("ocid_match_mode must be 'any' or 'all'"), nil
+	}
+
+	if allowedPrincipalTypes, ok := data.GetOk("allowed_principal_types"); ok {
+		roleEntry.AllowedPrincipalTypes, err = parseAllowedTypes("allowed_principal_types", allowedPrincipalTypes.([]string), principalTypes)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if allowedCredentialTypes, ok := data.GetOk("allowed_credential_types"); ok {
+		roleEntry.AllowedCredentialTypes, err = parseAllowedTypes("allowed_credential_types", allowedCredentialTypes.([]string), credentialTypes)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if loginRateLimit, ok := data.GetOk("login_rate_limit"); ok {
+		roleEntry.LoginRateLimit = loginRateLimit.(int)
+	}
+	if lockoutThreshold, ok := data.GetOk("lockout_threshold"); ok {
+		roleEntry.LockoutThreshold = lockoutThreshold.(int)
+	}
+	if lockoutDuration, ok := data.GetOk("lockout_duration"); ok {
+		roleEntry.LockoutDuration = time.Duration(lockoutDuration.(int)) * time.Second
+	} else if req.Operation == logical.CreateOperation {
+		roleEntry.LockoutDuration = time.Duration(data.Get("lockout_duration").(int)) * time.Second
+	}
+	if roleEntry.LoginRateLimit < 0 || roleEntry.LockoutThreshold < 0 {
+		return logical.ErrorResponse("login_rate_limit and lockout_threshold must not be negative"), nil
+	}
+	if roleEntry.LockoutThreshold > 0 && roleEntry.LockoutDuration <= 0 {
+		return logical.ErrorResponse("lockout_duration must be positive when lockout_threshold is set"), nil
+	}
+
 	if err := roleEntry.ParseTokenFields(req, data); err != nil {
 		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
 	}
@@ -197,6 +355,14 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 	if err := b.setOCIRole(ctx, req.Storage, roleName, roleEntry); err != nil {
 		return nil, err
 	}
+	// Principals may no longer qualify for the role once its membership requirements are tightened
+	if roleEntry.tightens(&previousEntry) {
+		if err := b.requestRevalidation(ctx, req.Storage, roleName); err != nil {
+			return nil, err
+		}
+	}
+
+	b.sendEvent(ctx, EventTypeRoleWrite, "path", req.Path, "role", roleName, "operation", string(req.Operation), "modified", "true")
 
 	return resp, nil
 }
@@ -206,6 +372,209 @@ type OCIRoleEntry struct {
 	tokenutil.TokenParams
 
 	OcidList []string `json:"ocid_list"`
+
+	// Whether principals must be a member of any or all of the OCIDs, any if empty
+	OcidMatchMode string `json:"ocid_match_mode,omitempty"`
+
+	// Boolean expression over OCIDs and their aliases that replaces OcidList if set
+	OcidExpression string            `json:"ocid_expression,omitempty"`
+	OcidAliases    map[string]string `json:"ocid_aliases,omitempty"`
+
+	// OCIDs whose members may not take the role
+	DeniedOcidList []string `json:"denied_ocid_list,omitempty"`
+
+	// Types of principal and of credential that may take the role, the defaults if empty
+	AllowedPrincipalTypes  []string `json:"allowed_principal_types,omitempty"`
+	AllowedCredentialTypes []string `json:"allowed_credential_types,omitempty"`
+
+	// Per principal login limits, disabled if 0
+	LoginRateLimit   int           `json:"login_rate_limit,omitempty"`
+	LockoutThreshold int           `json:"lockout_threshold,omitempty"`
+	LockoutDuration  time.Duration `json:"lockout_duration,omitempty"`
+}
+
+// ocidMatchMode returns whether principals must be a member of any or all of the OCIDs of the role, which is
+// any for roles written before it could be set
+func (r *OCIRoleEntry) ocidMatchMode() string {
+	if r.OcidMatchMode == "" {
+		return OcidMatchModeAny
+	}
+	return r.OcidMatchMode
+}
+
+// expression returns the parsed ocid_expression of the role, or nil if it has none
+func (r *OCIRoleEntry) expression() (ocidExpression, error) {
+	if r.OcidExpression == "" {
+		return nil, nil
+	}
+	return parseOcidExpression(r.OcidExpression, r.OcidAliases)
+}
+
+// membershipOcids returns the OCIDs whose membership decides whether a principal qualifies for the role
+func (r *OCIRoleEntry) membershipOcids() []string {
+	ocids := append([]string{}, r.OcidList...)
+	if expression, err := r.expression(); err == nil && expression != nil {
+		ocids = append(ocids, expression.ocids()...)
+	}
+	return append(ocids, r.DeniedOcidList...)
+}
+
+// checkMembership returns an error if the OCIDs the principal is a member of do not qualify it for the role:
+// the principal must not be a member of any denied OCID, and must satisfy the ocid_expression of the role,
+// or else be a member of any or all of the OCIDs
+func (r *OCIRoleEntry) checkMembership(filteredOcidMap map[string]string) error {
+	for _, item := range r.DeniedOcidList {
+		if _, present := filteredOcidMap[item]; present {
+			return fmt.Errorf("Entity is a part of the denied OCID %s", item)
+		}
+	}
+
+	expression, err := r.expression()
+	if err != nil {
+		return fmt.Errorf("Role OCID expression is invalid: %w", err)
+	}
+	if expression != nil {
+		if !expression.evaluate(filteredOcidMap) {
+			return fmt.Errorf("Entity does not satisfy the Role OCID expression")
+		}
+		return nil
+	}
+
+	matched := 0
+	for _, item := range r.OcidList {
+		if _, present := filteredOcidMap[item]; present {
+			matched++
+		}
+	}
+	if r.ocidMatchMode() == OcidMatchModeAll {
+		if len(r.OcidList) == 0 || matched < len(r.OcidList) {
+			return fmt.Errorf("Entity not a part of all of the Role OCIDs")
+		}
+		return nil
+	}
+	if matched == 0 {
+		return fmt.Errorf("Entity not a part of any of the Role OCIDs")
+	}
+	return nil
+}
+
+// qualifies returns whether the OCIDs the principal is a member of qualify it for the role
+func (r *OCIRoleEntry) qualifies(filteredOcidMap map[string]string) bool {
+	return r.checkMembership(filteredOcidMap) == nil
+}
+
+// tightens returns whether principals that qualified for the previous version of the role may no longer qualify
+func (r *OCIRoleEntry) tightens(previous *OCIRoleEntry) bool {
+	if r.OcidExpression != previous.OcidExpression || !reflect.DeepEqual(r.OcidAliases, previous.OcidAliases) {
+		return true
+	}
+	if r.ocidMatchMode() == OcidMatchModeAll {
+		return previous.ocidMatchMode() != OcidMatchModeAll || len(strutil.Difference(r.OcidList, previous.OcidList, false)) > 0 ||
+			len(strutil.Difference(r.DeniedOcidList, previous.DeniedOcidList, false)) > 0
+	}
+	return len(strutil.Difference(previous.OcidList, r.OcidList, false)) > 0 ||
+		len(strutil.Difference(r.DeniedOcidList, previous.DeniedOcidList, false)) > 0
+}
+
+// allowedPrincipalTypes returns the types of principal that may take the role, which are instances and users
+// for roles written before they could be set
+func (r *OCIRoleEntry) allowedPrincipalTypes() []string {
+	if len(r.AllowedPrincipalTypes) == 0 {
+		return append([]string{}, defaultAllowedPrincipalTypes...)
+	}
+	return append([]string{}, r.AllowedPrincipalTypes...)
+}
+
+// allowedCredentialTypes returns the types of credential that logins with the role may be signed with
+func (r *OCIRoleEntry) allowedCredentialTypes() []string {
+	if len(r.AllowedCredentialTypes) == 0 {
+		return append([]string{}, credentialTypes...)
+	}
+	return append([]string{}, r.AllowedCredentialTypes...)
+}
+
+// admits returns an error if the role may not be taken by the type of principal or with the type of credential
+func (r *OCIRoleEntry) admits(principalType, credentialType string) error {
+	if !strutil.StrListContains(r.allowedPrincipalTypes(), principalType) {
+		return fmt.Errorf("principal type %q is not allowed", principalType)
+	}
+	if !strutil.StrListContains(r.allowedCredentialTypes(), credentialType) {
+		return fmt.Errorf("credential type %q is not allowed", credentialType)
+	}
+	return nil
+}
+
+// mergeRoleEntries returns a role entry that grants the union of the policies of the given roles, with the
+// tightest of their TTLs, periods and use limits. Roles whose other token settings differ, such as their
+// token_bound_cidrs, can not be merged, since no token could honor the settings of each of them.
+func mergeRoleEntries(roleNames []string, roleEntries map[string]*OCIRoleEntry) (*OCIRoleEntry, error) {
+	if len(roleNames) == 1 {
+		return roleEntries[roleNames[0]], nil
+	}
+
+	first := roleEntries[roleNames[0]]
+	merged := &OCIRoleEntry{
+		TokenParams: first.TokenParams,
+	}
+	merged.TokenPolicies = nil
+	for _, name := range roleNames {
+		roleEntry := roleEntries[name]
+		if field := differingTokenSetting(first, roleEntry); field != "" {
+			return nil, fmt.Errorf("roles %q and %q can not be merged, their %s differ", roleNames[0], name, field)
+		}
+		merged.OcidList = append(merged.OcidList, roleEntry.OcidList...)
+		merged.TokenPolicies = append(merged.TokenPolicies, roleEntry.TokenPolicies...)
+		merged.TokenTTL = minNonZeroDuration(merged.TokenTTL, roleEntry.TokenTTL)
+		merged.TokenMaxTTL = minNonZeroDuration(merged.TokenMaxTTL, roleEntry.TokenMaxTTL)
+		merged.TokenExplicitMaxTTL = minNonZeroDuration(merged.TokenExplicitMaxTTL, roleEntry.TokenExplicitMaxTTL)
+		merged.TokenPeriod = minNonZeroDuration(merged.TokenPeriod, roleEntry.TokenPeriod)
+		if roleEntry.TokenNumUses > 0 && (merged.TokenNumUses == 0 || roleEntry.TokenNumUses < merged.TokenNumUses) {
+			merged.TokenNumUses = roleEntry.TokenNumUses
+		}
+	}
+	merged.OcidList = strutil.RemoveDuplicatesStable(merged.OcidList, false)
+	merged.TokenPolicies = strutil.RemoveDuplicatesStable(merged.TokenPolicies, false)
+
+	return merged, nil
+}
+
+// differingTokenSetting returns the name of the first token setting other than the policies, TTLs,
+// periods and use limits that differs between two roles, or "" if they are the same. A periodic role
+// also differs from a role without a period, since periodic tokens are not bound by token_max_ttl.
+func differingTokenSetting(a, b *OCIRoleEntry) string {
+	switch {
+	case (a.TokenPeriod == 0) != (b.TokenPeriod == 0):
+		return "token_period"
+	case !strutil.EquivalentSlices(boundCIDRStrings(a), boundCIDRStrings(b)):
+		return "token_bound_cidrs"
+	case a.TokenType != b.TokenType:
+		return "token_type"
+	case a.TokenNoDefaultPolicy != b.TokenNoDefaultPolicy:
+		return "token_no_default_policy"
+	}
+	return ""
+}
+
+// boundCIDRStrings returns the token_bound_cidrs of a role as strings
+func boundCIDRStrings(roleEntry *OCIRoleEntry) []string {
+	cidrs := make([]string, 0, len(roleEntry.TokenBoundCIDRs))
+	for _, cidr := range roleEntry.TokenBoundCIDRs {
+		cidrs = append(cidrs, cidr.String())
+	}
+	return cidrs
+}
+
+// minNonZeroDuration returns the shorter of two durations, where zero means unset
+func minNonZeroDuration(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// tracksLogins returns whether the logins of each principal must be tracked for this role
+func (r *OCIRoleEntry) tracksLogins() bool {
+	return r.LoginRateLimit > 0 || r.LockoutThreshold > 0
 }
 
 const pathRoleSyn = `
@@ -0,0 +1,481 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// These constants store the storage prefix and bounds of the tracked tokens
+const (
+	trackedTokensStoragePrefix = "tokens/"
+
+	// Only the most recent accessors of a principal are tracked
+	maxTrackedAccessors = 100
+)
+
+func pathTokens(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/" + framework.GenericNameRegex("subject_id"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationSuffix: "tokens",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"subject_id": {
+				Type:        framework.TypeString,
+				Description: "OCID of the user or instance principal.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathTokensRead,
+		},
+
+		HelpSynopsis:    pathTokensSyn,
+		HelpDescription: pathTokensDesc,
+	}
+}
+
+func pathListTokens(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tokens/?",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "list",
+			OperationSuffix: "tokens",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathTokensList,
+		},
+
+		HelpSynopsis:    pathListTokensSyn,
+		HelpDescription: pathListTokensDesc,
+	}
+}
+
+func trackedTokensKey(subjectId string) string {
+	return trackedTokensStoragePrefix + strings.ToLower(subjectId)
+}
+
+// getOCITrackedTokens returns the tracked tokens of a principal
+func (b *backend) getOCITrackedTokens(ctx context.Context, s logical.Storage, subjectId string) (*OCITrackedTokensEntry, error) {
+	entry, err := s.Get(ctx, trackedTokensKey(subjectId))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var result OCITrackedTokensEntry
+	if err := entry.DecodeJSON(&result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// setOCITrackedTokens creates or updates the tracked tokens of a principal
+func (b *backend) setOCITrackedTokens(ctx context.Context, s logical.Storage, tracked *OCITrackedTokensEntry) error {
+	entry, err := logical.StorageEntryJSON(trackedTokensKey(tracked.SubjectId), tracked)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// trackLogin starts tracking the tokens issued to a principal for the given roles. The principal was just
+// validated for the roles by the login, so roles it no longer qualified for are tracked as valid again.
+func (b *backend) trackLogin(ctx context.Context, s logical.Storage, principal Principal, roleNames []string) error {
+	if principal.SubjectId == nil || *principal.SubjectId == "" {
+		return nil
+	}
+
+	b.trackedTokensMutex.Lock()
+	defer b.trackedTokensMutex.Unlock()
+
+	tracked, err := b.getOCITrackedTokens(ctx, s, *principal.SubjectId)
+	if err != nil {
+		return err
+	}
+	if tracked == nil {
+		tracked = &OCITrackedTokensEntry{
+			SubjectId: *principal.SubjectId,
+		}
+	}
+	if tracked.Roles == nil {
+		tracked.Roles = map[string]*OCITrackedRoleEntry{}
+	}
+
+	now := time.Now().UTC()
+	tracked.Principal = principal
+	tracked.LastSeen = now
+	tracked.LastValidated = now
+	tracked.RenewalDenied = false
+	tracked.RenewalDeniedReason = ""
+	for _, roleName := range roleNames {
+		role, ok := tracked.Roles[roleName]
+		if !ok {
+			role = &OCITrackedRoleEntry{}
+			tracked.Roles[roleName] = role
+		}
+		role.LastSeen = now
+		role.RenewalDenied = false
+		role.RenewalDeniedReason = ""
+	}
+
+	return b.setOCITrackedTokens(ctx, s, tracked)
+}
+
+// trackRenewal records the accessor of a token of a principal for the given roles that is being renewed, and
+// returns an error if the principal no longer qualifies for one of the roles of the token
+func (b *backend) trackRenewal(ctx context.Context, s logical.Storage, subjectId, accessor string, roleNames []string) error {
+	b.trackedTokensMutex.Lock()
+	defer b.trackedTokensMutex.Unlock()
+
+	tracked, err := b.getOCITrackedTokens(ctx, s, subjectId)
+	if err != nil {
+		return err
+	}
+	if tracked == nil {
+		return nil
+	}
+	if tracked.RenewalDenied {
+		return fmt.Errorf("principal %q no longer qualifies for its roles: %s", subjectId, tracked.RenewalDeniedReason)
+	}
+	if tracked.Roles == nil {
+		tracked.Roles = map[string]*OCITrackedRoleEntry{}
+	}
+	for _, roleName := range roleNames {
+		if role, ok := tracked.Roles[roleName]; ok && role.RenewalDenied {
+			return fmt.Errorf("principal %q no longer qualifies for role %q: %s", subjectId, roleName, role.RenewalDeniedReason)
+		}
+	}
+
+	now := time.Now().UTC()
+	tracked.LastSeen = now
+	for _, roleName := range roleNames {
+		role, ok := tracked.Roles[roleName]
+		if !ok {
+			role = &OCITrackedRoleEntry{}
+			tracked.Roles[roleName] = role
+		}
+		role.LastSeen = now
+		if accessor != "" && !strutil.StrListContains(role.Accessors, accessor) {
+			role.Accessors = append(role.Accessors, accessor)
+			if len(role.Accessors) > maxTrackedAccessors {
+				role.Accessors = role.Accessors[len(role.Accessors)-maxTrackedAccessors:]
+			}
+		}
+	}
+
+	return b.setOCITrackedTokens(ctx, s, tracked)
+}
+
+// requestRevalidation makes the next run of the periodic function re-validate the principals with tokens
+// for a role, such as after OCIDs were removed from the role or the role was deleted
+func (b *backend) requestRevalidation(ctx context.Context, s logical.Storage, roleName string) error {
+	b.trackedTokensMutex.Lock()
+	defer b.trackedTokensMutex.Unlock()
+
+	keys, err := s.List(ctx, trackedTokensStoragePrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		tracked, err := b.getOCITrackedTokens(ctx, s, key)
+		if err != nil {
+			return err
+		}
+		if tracked == nil || tracked.RenewalDenied {
+			continue
+		}
+		if role, ok := tracked.Roles[roleName]; !ok || role.RenewalDenied {
+			continue
+		}
+		tracked.LastValidated = time.Time{}
+		if err := b.setOCITrackedTokens(ctx, s, tracked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revalidateTokens re-runs the group membership check of the principals with tracked tokens that are due for
+// re-validation, and denies the renewal of the tokens of the roles they no longer qualify for. The tracked tokens
+// of those roles are revoked if token revocation is configured, otherwise they expire at the end of their TTL.
+func (b *backend) revalidateTokens(ctx context.Context, req *logical.Request) error {
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if configEntry == nil || configEntry.TokenRevalidationInterval <= 0 {
+		return nil
+	}
+
+	keys, err := req.Storage.List(ctx, trackedTokensStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	// OCI Identity is called without holding the lock, so that renewals are not blocked by the calls
+	accessors := map[string][]string{}
+	for _, key := range keys {
+		tracked, err := b.getOCITrackedTokens(ctx, req.Storage, key)
+		if err != nil {
+			return err
+		}
+		if tracked == nil {
+			continue
+		}
+
+		// Roles without a token seen for longer than the maximum lease TTL are no longer tracked
+		started := time.Now()
+		maxLeaseTTL := b.System().MaxLeaseTTL()
+		if started.Sub(tracked.LastSeen) > maxLeaseTTL {
+			if err := b.updateTrackedTokens(ctx, req.Storage, key, started, func(tracked *OCITrackedTokensEntry) bool {
+				return false
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		if tracked.RenewalDenied || started.Sub(tracked.LastValidated) < configEntry.TokenRevalidationInterval {
+			continue
+		}
+
+		reasons, err := b.revalidatePrincipal(ctx, req, tracked)
+		if err != nil {
+			// The principal is validated again on the next run, rather than denied renewal because of an outage
+			b.Logger().Warn("unable to re-validate principal", "subject_id", tracked.SubjectId, "error", err)
+			continue
+		}
+
+		if err := b.updateTrackedTokens(ctx, req.Storage, key, started, func(tracked *OCITrackedTokensEntry) bool {
+			tracked.LastValidated = started.UTC()
+			for roleName, role := range tracked.Roles {
+				if started.Sub(role.LastSeen) > maxLeaseTTL {
+					delete(tracked.Roles, roleName)
+					continue
+				}
+				reason, ok := reasons[roleName]
+				if !ok || role.RenewalDenied {
+					continue
+				}
+				role.RenewalDenied = true
+				role.RenewalDeniedReason = reason
+				accessors[tracked.SubjectId] = append(accessors[tracked.SubjectId], role.Accessors...)
+				b.Logger().Warn("denying the renewal of the tokens of a principal that no longer qualifies for their role",
+					"subject_id", tracked.SubjectId, "role", roleName, "reason", reason, "accessors", strings.Join(role.Accessors, ","))
+			}
+			return len(tracked.Roles) > 0
+		}); err != nil {
+			return err
+		}
+	}
+
+	if len(accessors) == 0 || configEntry.TokenRevocationAddress == "" {
+		return nil
+	}
+	for subjectId := range accessors {
+		accessors[subjectId] = strutil.RemoveDuplicatesStable(accessors[subjectId], false)
+	}
+	// Accessors that are not revoked are logged and reported in the token_revocation_status of the config
+	_, _, err = b.revokeTrackedAccessors(ctx, req, configEntry, accessors)
+	return err
+}
+
+// updateTrackedTokens applies the result of a re-validation that started at the given time to the tracked
+// tokens of a principal, which are deleted if update returns false. Principals that logged in since the
+// re-validation started were validated by the login, and are left as they are.
+func (b *backend) updateTrackedTokens(ctx context.Context, s logical.Storage, subjectId string, started time.Time,
+	update func(*OCITrackedTokensEntry) bool) error {
+
+	b.trackedTokensMutex.Lock()
+	defer b.trackedTokensMutex.Unlock()
+
+	tracked, err := b.getOCITrackedTokens(ctx, s, subjectId)
+	if err != nil {
+		return err
+	}
+	if tracked == nil || tracked.LastValidated.After(started) {
+		return nil
+	}
+
+	if !update(tracked) {
+		return s.Delete(ctx, trackedTokensKey(subjectId))
+	}
+	return b.setOCITrackedTokens(ctx, s, tracked)
+}
+
+// revalidatePrincipal checks whether a principal still qualifies for the roles of its tokens that are not denied
+// renewal yet, and returns the reason it does not for each role it no longer qualifies for
+func (b *backend) revalidatePrincipal(ctx context.Context, req *logical.Request, tracked *OCITrackedTokensEntry) (map[string]string, error) {
+	reasons := map[string]string{}
+	roleEntries := make(map[string]*OCIRoleEntry, len(tracked.Roles))
+	ocids := []string{}
+	for roleName, role := range tracked.Roles {
+		if role.RenewalDenied {
+			continue
+		}
+		roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil {
+			reasons[roleName] = "role was deleted"
+			continue
+		}
+		roleEntries[roleName] = roleEntry
+		ocids = append(ocids, roleEntry.membershipOcids()...)
+	}
+	if len(roleEntries) == 0 {
+		return reasons, nil
+	}
+
+	authClient, err := b.getOrCreateAuthClient(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	requestMetadata := common.RequestMetadata{
+		RetryPolicy: nil,
+	}
+	filteredOcidMap, err := b.filterGroupMembership(ctx, req, authClient, tracked.Principal, strutil.RemoveDuplicates(ocids, false), requestMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	for roleName, roleEntry := range roleEntries {
+		if err := roleEntry.checkMembership(filteredOcidMap); err != nil {
+			reasons[roleName] = fmt.Sprintf("principal no longer qualifies for role %q: %s", roleName, err)
+		}
+	}
+	return reasons, nil
+}
+
+func (b *backend) pathTokensRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tracked, err := b.getOCITrackedTokens(ctx, req.Storage, data.Get("subject_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if tracked == nil {
+		return nil, nil
+	}
+
+	roles := make(map[string]interface{}, len(tracked.Roles))
+	for roleName, role := range tracked.Roles {
+		roles[roleName] = map[string]interface{}{
+			"last_seen":             role.LastSeen.Format(time.RFC3339),
+			"accessors":             append([]string{}, role.Accessors...),
+			"renewal_denied":        role.RenewalDenied,
+			"renewal_denied_reason": role.RenewalDeniedReason,
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"subject_id":            tracked.SubjectId,
+			"roles":                 roles,
+			"accessors":             tracked.accessors(),
+			"last_validated":        tracked.LastValidated.Format(time.RFC3339),
+			"renewal_denied":        tracked.RenewalDenied,
+			"renewal_denied_reason": tracked.RenewalDeniedReason,
+		},
+	}, nil
+}
+
+func (b *backend) pathTokensList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, trackedTokensStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(entries), nil
+}
+
+// Struct to hold the tokens issued to a principal while token re-validation is enabled
+type OCITrackedTokensEntry struct {
+	SubjectId string `json:"subject_id"`
+
+	// The principal as authenticated by OCI Identity, to re-run its group membership check
+	Principal Principal `json:"principal"`
+
+	// Tokens of the principal for each role it logged in with. A token issued for several roles is tracked
+	// for each of them.
+	Roles map[string]*OCITrackedRoleEntry `json:"roles"`
+
+	// Last login or renewal by the principal, and last re-validation
+	LastSeen      time.Time `json:"last_seen"`
+	LastValidated time.Time `json:"last_validated"`
+
+	// Set by an OCI event that revokes the principal, which denies the renewal of all its tokens
+	RenewalDenied       bool   `json:"renewal_denied"`
+	RenewalDeniedReason string `json:"renewal_denied_reason,omitempty"`
+}
+
+// Struct to hold the tokens issued to a principal for a role
+type OCITrackedRoleEntry struct {
+	// Last login or renewal of a token for the role
+	LastSeen time.Time `json:"last_seen"`
+
+	// Accessors of the tokens for the role. Vault does not pass the accessor of a token to the login that
+	// issues it, so they are recorded when the tokens are renewed.
+	Accessors []string `json:"accessors"`
+
+	// Set once the principal no longer qualifies for the role, which denies the renewal of its tokens for the role
+	RenewalDenied       bool   `json:"renewal_denied"`
+	RenewalDeniedReason string `json:"renewal_denied_reason,omitempty"`
+}
+
+// accessors returns the accessors of the tokens of the principal for all its roles
+func (e *OCITrackedTokensEntry) accessors() []string {
+	accessors := []string{}
+	for _, role := range e.Roles {
+		accessors = append(accessors, role.Accessors...)
+	}
+	return strutil.RemoveDuplicates(accessors, false)
+}
+
+// removeAccessors stops tracking the given accessors for all the roles of the principal
+func (e *OCITrackedTokensEntry) removeAccessors(accessors []string) {
+	for _, role := range e.Roles {
+		role.Accessors = strutil.Difference(role.Accessors, accessors, false)
+	}
+}
+
+const pathTokensSyn = `
+Reads the tracked tokens of a principal.
+`
+
+const pathTokensDesc = `
+When token_revalidation_interval is set on the config, the tokens issued to
+each principal are tracked, keyed on the subject OCID of the principal. Reading
+returns the roles of the tokens, the accessors of the tokens that were renewed
+for each role, and whether the renewal of the tokens for a role is denied
+because the principal no longer qualifies for the role. Tokens denied renewal
+are revoked through the token_revocation_address of the config if it is set,
+otherwise they expire at the end of their current TTL. Tokens that were not
+renewed yet have no accessor recorded, so they can not be revoked.
+`
+
+const pathListTokensSyn = `
+Lists the principals with tracked tokens.
+`
+
+const pathListTokensDesc = `
+Principals will be listed by their subject OCIDs, including principals whose
+tokens are denied renewal.
+`
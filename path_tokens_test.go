@@ -0,0 +1,150 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_TokenRevalidation(t *testing.T) {
+	subjectId := "ocid1.instance.oc1.phx.aaaatest"
+	b, storage := setupTestLoginBackend(t, "devrole", map[string]interface{}{"token_revalidation_interval": 60})
+	identity := &fakeIdentity{
+		subjectId:     subjectId,
+		principalType: PrincipalTypeInstance,
+		groupIds:      []string{"ocid1"},
+	}
+	useFakeIdentity(t, b, identity)
+
+	login := func(t *testing.T, role string) *logical.Auth {
+		headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role), nil)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + role,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil || resp == nil || resp.Auth == nil {
+			t.Fatalf("Login failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp.Auth
+	}
+
+	renew := func(auth *logical.Auth, accessor string) (*logical.Response, error) {
+		auth.Accessor = accessor
+		auth.TokenPolicies = auth.Policies
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "login",
+			Storage:   storage,
+			Auth:      auth,
+		})
+	}
+
+	readTokens := func(t *testing.T) map[string]interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "tokens/" + subjectId,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("Tokens read failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp.Data
+	}
+
+	roleTokens := func(t *testing.T, role string) map[string]interface{} {
+		tokens, ok := readTokens(t)["roles"].(map[string]interface{})[role].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected the tokens of role %q to be tracked", role)
+		}
+		return tokens
+	}
+
+	auth := login(t, "devrole")
+	if !auth.Renewable {
+		t.Fatal("expected a renewable token while tokens are re-validated")
+	}
+	if resp, err := renew(auth, "accessor1"); err != nil || resp == nil || resp.Auth == nil {
+		t.Fatalf("Renewal failed. resp:%#v\n err:%v", resp, err)
+	}
+	if accessors := readTokens(t)["accessors"]; !reflect.DeepEqual(accessors, []string{"accessor1"}) {
+		t.Fatalf("expected the accessor to be tracked, got: %v", accessors)
+	}
+
+	// The principal leaves the group, and the OCIDs of the role are edited
+	identity.groupIds = []string{"ocid9"}
+	if err := b.revalidateTokens(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if roleTokens(t, "devrole")["renewal_denied"] != false {
+		t.Fatal("expected the principal not to be re-validated before the interval elapsed")
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/devrole",
+		Storage:   storage,
+		Data:      map[string]interface{}{"ocid_list": "ocid1"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Role update failed. resp:%#v\n err:%v", resp, err)
+	}
+	if err := b.revalidateTokens(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := roleTokens(t, "devrole")
+	if tokens["renewal_denied"] != true || !strings.Contains(tokens["renewal_denied_reason"].(string), "devrole") {
+		t.Fatalf("expected the renewal of the tokens of the principal to be denied, got: %#v", tokens)
+	}
+	if _, err := renew(auth, "accessor1"); err == nil {
+		t.Fatal("expected the renewal of the token to be denied")
+	}
+
+	// A new login validates the principal again
+	identity.groupIds = []string{"ocid1"}
+	auth = login(t, "devrole")
+	if roleTokens(t, "devrole")["renewal_denied"] != false {
+		t.Fatal("expected the login to validate the principal again")
+	}
+	if _, err := renew(auth, "accessor2"); err != nil {
+		t.Fatalf("Renewal failed: %v", err)
+	}
+
+	// Moving to another role only denies the renewal of the tokens of the deleted role
+	if err := createRole(map[string]interface{}{"ocid_list": "ocid1"}, "opsrole", b, &logical.BackendConfig{StorageView: storage}); err != nil {
+		t.Fatal(err)
+	}
+	opsAuth := login(t, "opsrole")
+	if _, err := renew(opsAuth, "accessor3"); err != nil {
+		t.Fatalf("Renewal failed: %v", err)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/devrole",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Role deletion failed. resp:%#v\n err:%v", resp, err)
+	}
+	if err := b.revalidateTokens(context.Background(), &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	if tokens := roleTokens(t, "devrole"); tokens["renewal_denied"] != true || tokens["renewal_denied_reason"] != "role was deleted" {
+		t.Fatalf("expected the renewal of the tokens of the deleted role to be denied, got: %#v", tokens)
+	}
+	if tokens := roleTokens(t, "opsrole"); tokens["renewal_denied"] != false {
+		t.Fatalf("expected the tokens of the other role to stay renewable, got: %#v", tokens)
+	}
+	if _, err := renew(opsAuth, "accessor3"); err != nil {
+		t.Fatalf("Renewal failed: %v", err)
+	}
+}
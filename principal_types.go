@@ -0,0 +1,80 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+// These constants define the types of principal of OCI services, in addition to users and instances
+const (
+	PrincipalTypeResource = "resource"
+	PrincipalTypeWorkload = "workload"
+)
+
+// These constants define the types of credential a login can be signed with
+const (
+	CredentialTypeAPIKey              = "api_key"
+	CredentialTypeSessionToken        = "session_token"
+	CredentialTypeInstanceCertificate = "instance_certificate"
+	CredentialTypeResourcePrincipal   = "resource_principal_token"
+)
+
+// ClaimResourceType is the claim naming the type of resource of resource principals and workloads
+const ClaimResourceType = "res_type"
+
+// securityTokenKeyIdPrefix starts the keyId of signatures made with a security token rather than an API key
+const securityTokenKeyIdPrefix = "ST$"
+
+// principalTypes are the types of principal that can log in
+var principalTypes = []string{PrincipalTypeInstance, PrincipalTypeUser, PrincipalTypeResource, PrincipalTypeWorkload}
+
+// defaultAllowedPrincipalTypes are the types of principal allowed by roles without allowed_principal_types
+var defaultAllowedPrincipalTypes = []string{PrincipalTypeInstance, PrincipalTypeUser}
+
+// credentialTypes are the types of credential a login can be signed with
+var credentialTypes = []string{CredentialTypeAPIKey, CredentialTypeSessionToken, CredentialTypeInstanceCertificate, CredentialTypeResourcePrincipal}
+
+// credentialTypeOf returns the type of credential a login was signed with. Signatures whose keyId is not a
+// security token are made with an API key. Security tokens are told apart by the claims of the principal
+// authenticated by OCI Identity: instances obtain theirs with their instance certificate, resource principals
+// and workloads, which carry a res_type claim, are issued theirs by the OCI service they run in, and users
+// obtain theirs from a session.
+func credentialTypeOf(requestHeaders http.Header, claims InternalClaims) string {
+	params, err := parseSignatureParams(requestHeaders)
+	if err != nil || !strings.HasPrefix(params["keyid"], securityTokenKeyIdPrefix) {
+		return CredentialTypeAPIKey
+	}
+
+	switch claims.GetString(ClaimPrincipalType) {
+	case PrincipalTypeInstance:
+		return CredentialTypeInstanceCertificate
+	case PrincipalTypeResource, PrincipalTypeWorkload:
+		return CredentialTypeResourcePrincipal
+	case PrincipalTypeUser:
+		return CredentialTypeSessionToken
+	}
+	if claims.GetString(ClaimResourceType) != "" {
+		return CredentialTypeResourcePrincipal
+	}
+	return CredentialTypeSessionToken
+}
+
+// parseAllowedTypes returns the lower cased types of a list, or an error naming the field if a type is not valid
+func parseAllowedTypes(field string, types []string, validTypes []string) ([]string, error) {
+	parsed := []string{}
+	for _, t := range types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if !strutil.StrListContains(validTypes, t) {
+			return nil, fmt.Errorf("%s contains an invalid type %q, it must be one of %s", field, t, strings.Join(validTypes, ", "))
+		}
+		parsed = append(parsed, t)
+	}
+	return strutil.RemoveDuplicatesStable(parsed, false), nil
+}
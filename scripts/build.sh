@@ -42,7 +42,7 @@ mkdir -p bin/
 echo "==> Building..."
 ${GO_CMD} build \
     -gcflags "${GCFLAGS}" \
-    -ldflags "-X github.com/hashicorp/${TOOL}/version.GitCommit='${GIT_COMMIT}${GIT_DIRTY}'" \
+    -ldflags "-X github.com/hashicorp/${TOOL}/version.GitCommit='${GIT_COMMIT}${GIT_DIRTY}' ${VERSION:+-X github.com/hashicorp/${TOOL}/version.Version=${VERSION}}" \
     -o "bin/${TOOL}" \
     -tags "${BUILD_TAGS}" \
     "${DIR}/cmd/${TOOL}"
@@ -0,0 +1,189 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+// These constants store the names of headers inspected when validating the signed request
+const (
+	// HdrAuthorization is the header carrying the signature of the login request
+	HdrAuthorization = "authorization"
+
+	// HdrVaultServerID is the header binding a login signature to a Vault server
+	HdrVaultServerID = "x-vault-oci-server-id"
+
+	// HdrVaultNamespace is the header naming the Vault namespace of a request
+	HdrVaultNamespace = "x-vault-namespace"
+)
+
+// These constants limit the size of the login headers that are accepted and forwarded to OCI Identity
+const (
+	MaxLoginHeaderCount     = 32
+	MaxLoginHeaderValueSize = 8 * 1024
+	MaxLoginHeadersSize     = 16 * 1024
+)
+
+// requiredSignedHeaders are the headers that every login signature must cover, so that a
+// signature can not be replayed against another path or host
+var requiredSignedHeaders = []string{"date", HdrRequestTarget, "host"}
+
+// signatureParamRegex matches the key="value" parameters of a Signature authorization header
+var signatureParamRegex = regexp.MustCompile(`([a-zA-Z]+)="([^"]*)"`)
+
+// parseSignatureParams returns the parameters of the Signature in the Authorization header
+func parseSignatureParams(requestHeaders http.Header) (map[string]string, error) {
+	authorization := requestHeaders.Values(HdrAuthorization)
+	if len(authorization) == 0 {
+		return nil, fmt.Errorf("no authorization specified in header")
+	}
+	if len(authorization) > 1 {
+		return nil, fmt.Errorf("multiple authorization values specified in header")
+	}
+
+	value := strings.TrimSpace(authorization[0])
+	if !strings.HasPrefix(strings.ToLower(value), "signature ") {
+		return nil, fmt.Errorf("authorization header is not a signature")
+	}
+
+	params := make(map[string]string)
+	for _, match := range signatureParamRegex.FindAllStringSubmatch(value, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	return params, nil
+}
+
+// parseSignedHeaders returns the lower cased names of the headers covered by the signature
+func parseSignedHeaders(requestHeaders http.Header) ([]string, error) {
+	params, err := parseSignatureParams(requestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	signedHeaders := strings.Fields(strings.ToLower(params["headers"]))
+	if len(signedHeaders) == 0 {
+		return nil, fmt.Errorf("no signed headers specified in authorization header")
+	}
+
+	seen := make(map[string]string, len(signedHeaders))
+	for _, name := range signedHeaders {
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("header %q is signed more than once", name)
+		}
+		seen[name] = name
+	}
+	return signedHeaders, nil
+}
+
+// validateHeaderLimits ensures that the login headers are within the count and size limits
+func validateHeaderLimits(requestHeaders http.Header) error {
+	if len(requestHeaders) > MaxLoginHeaderCount {
+		return fmt.Errorf("too many headers specified, the limit is %d", MaxLoginHeaderCount)
+	}
+
+	totalSize := 0
+	for name, values := range requestHeaders {
+		for _, value := range values {
+			if len(value) > MaxLoginHeaderValueSize {
+				return fmt.Errorf("header %q exceeds the size limit of %d bytes", strings.ToLower(name), MaxLoginHeaderValueSize)
+			}
+			totalSize += len(name) + len(value)
+		}
+	}
+	if totalSize > MaxLoginHeadersSize {
+		return fmt.Errorf("headers exceed the total size limit of %d bytes", MaxLoginHeadersSize)
+	}
+
+	return nil
+}
+
+// filterSignedHeaders returns only the headers covered by the signature and the authorization
+// header itself, which are the only headers OCI Identity needs to authenticate the request
+func filterSignedHeaders(requestHeaders http.Header) (http.Header, error) {
+	signedHeaders, err := parseSignedHeaders(requestHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedHeaderMap := sliceToMap(append(signedHeaders, HdrAuthorization))
+	filteredHeaders := http.Header{}
+	for name, values := range requestHeaders {
+		if _, ok := allowedHeaderMap[strings.ToLower(name)]; ok {
+			filteredHeaders[name] = values
+		}
+	}
+	return filteredHeaders, nil
+}
+
+// validateSignedHeaders ensures that the signature covers date, (request-target), host and any
+// additionally required headers, and that each of them is present in the request headers
+func validateSignedHeaders(requestHeaders http.Header, additionalHeaders []string) error {
+	signedHeaders, err := parseSignedHeaders(requestHeaders)
+	if err != nil {
+		return err
+	}
+	signedHeaderMap := sliceToMap(signedHeaders)
+
+	for _, required := range append(append([]string{}, requiredSignedHeaders...), additionalHeaders...) {
+		required = strings.ToLower(required)
+		if _, ok := signedHeaderMap[required]; !ok {
+			return fmt.Errorf("signature does not cover the required header %q", required)
+		}
+		if len(requestHeaders.Values(required)) == 0 {
+			return fmt.Errorf("signed header %q is not specified in header", required)
+		}
+	}
+
+	for _, name := range signedHeaders {
+		if len(requestHeaders.Values(name)) > 1 {
+			return fmt.Errorf("signed header %q is specified more than once", name)
+		}
+	}
+
+	return nil
+}
+
+// signedHeadersForConfig returns the headers that the config requires login signatures to cover
+// in addition to date, (request-target) and host
+func signedHeadersForConfig(configEntry *OCIConfigEntry) []string {
+	if configEntry == nil {
+		return nil
+	}
+
+	headers := append([]string{}, configEntry.RequiredSignedHeaders...)
+	if configEntry.ServerIdHeaderValue != "" {
+		headers = append(headers, HdrVaultServerID)
+	}
+	return headers
+}
+
+// validateServerBinding ensures that the signed host and server ID headers match this Vault server
+func validateServerBinding(requestHeaders http.Header, configEntry *OCIConfigEntry) error {
+	if configEntry == nil {
+		return nil
+	}
+
+	if configEntry.ServerIdHeaderValue != "" {
+		serverIDs := requestHeaders.Values(HdrVaultServerID)
+		if len(serverIDs) != 1 || serverIDs[0] != configEntry.ServerIdHeaderValue {
+			return fmt.Errorf("invalid %s header value", HdrVaultServerID)
+		}
+	}
+
+	if len(configEntry.AllowedHosts) > 0 {
+		hosts := requestHeaders.Values("host")
+		if len(hosts) != 1 {
+			return fmt.Errorf("a single host must be specified in header")
+		}
+		if !strutil.StrListContains(configEntry.AllowedHosts, strings.ToLower(hosts[0])) {
+			return fmt.Errorf("host %q is not allowed", hosts[0])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func testSignatureHeaders(signedHeaders string) http.Header {
+	headers := http.Header{}
+	headers.Set("date", "Thu, 05 Jan 2023 21:31:40 GMT")
+	headers.Set("host", "vault.example.com")
+	headers.Set(HdrRequestTarget, "get /v1/auth/oci/login/devrole")
+	headers.Set("x-vault-oci-test", "value")
+	headers.Set(HdrAuthorization, `Signature version="1",headers="`+signedHeaders+`",keyId="ocid1.tenancy.oc1..t/ocid1.user.oc1..u/aa:bb",algorithm="rsa-sha256",signature="c2ln"`)
+	return headers
+}
+
+func TestValidateSignedHeaders(t *testing.T) {
+	if err := validateSignedHeaders(testSignatureHeaders("date (request-target) host"), nil); err != nil {
+		t.Fatalf("expected the default headers to be accepted: %v", err)
+	}
+
+	if err := validateSignedHeaders(testSignatureHeaders("date (request-target) host x-vault-oci-test"), []string{"X-Vault-OCI-Test"}); err != nil {
+		t.Fatalf("expected an additionally required header to be accepted: %v", err)
+	}
+
+	for _, signedHeaders := range []string{"date (request-target)", "date host", "(request-target) host", ""} {
+		if err := validateSignedHeaders(testSignatureHeaders(signedHeaders), nil); err == nil {
+			t.Fatalf("expected signature over %q to be rejected", signedHeaders)
+		}
+	}
+
+	err := validateSignedHeaders(testSignatureHeaders("date (request-target) host"), []string{"x-vault-oci-test"})
+	if err == nil || !strings.Contains(err.Error(), "x-vault-oci-test") {
+		t.Fatalf("expected the missing additional header to be reported, got: %v", err)
+	}
+
+	headers := testSignatureHeaders("date (request-target) host")
+	headers.Del("host")
+	if err := validateSignedHeaders(headers, nil); err == nil {
+		t.Fatal("expected a signed header missing from the request to be rejected")
+	}
+
+	headers = testSignatureHeaders("date (request-target) host")
+	headers.Add(HdrAuthorization, headers.Get(HdrAuthorization))
+	if err := validateSignedHeaders(headers, nil); err == nil {
+		t.Fatal("expected multiple authorization headers to be rejected")
+	}
+
+	headers = testSignatureHeaders("date (request-target) host")
+	headers.Set(HdrAuthorization, "Bearer token")
+	if err := validateSignedHeaders(headers, nil); err == nil {
+		t.Fatal("expected a non signature authorization header to be rejected")
+	}
+}
+
+func TestValidateServerBinding(t *testing.T) {
+	configEntry := &OCIConfigEntry{
+		AllowedHosts:        []string{"vault.example.com", "vault.example.com:8200"},
+		ServerIdHeaderValue: "vault-prod",
+	}
+
+	headers := testSignatureHeaders("date (request-target) host x-vault-oci-server-id")
+	headers.Set(HdrVaultServerID, "vault-prod")
+	if err := validateServerBinding(headers, configEntry); err != nil {
+		t.Fatalf("expected the server binding to be accepted: %v", err)
+	}
+
+	headers.Set("host", "VAULT.example.com:8200")
+	if err := validateServerBinding(headers, configEntry); err != nil {
+		t.Fatalf("expected hosts to be compared case insensitively: %v", err)
+	}
+
+	headers.Set("host", "vault-dev.example.com")
+	if err := validateServerBinding(headers, configEntry); err == nil {
+		t.Fatal("expected a host that is not allowed to be rejected")
+	}
+
+	headers.Set("host", "vault.example.com")
+	headers.Set(HdrVaultServerID, "vault-dev")
+	if err := validateServerBinding(headers, configEntry); err == nil {
+		t.Fatal("expected a mismatched server ID to be rejected")
+	}
+
+	headers.Del(HdrVaultServerID)
+	if err := validateServerBinding(headers, configEntry); err == nil {
+		t.Fatal("expected a missing server ID to be rejected")
+	}
+
+	if err := validateServerBinding(headers, &OCIConfigEntry{}); err != nil {
+		t.Fatalf("expected no binding to be enforced without config: %v", err)
+	}
+}
+
+func TestSignedServerIDHeader(t *testing.T) {
+	headers := signTestLoginRequest(t, "https://vault.example.com", "/v1/auth/oci/login/devrole", map[string]string{
+		HdrVaultServerID: "vault-prod",
+	})
+
+	configEntry := &OCIConfigEntry{ServerIdHeaderValue: "vault-prod"}
+	if err := validateSignedHeaders(headers, signedHeadersForConfig(configEntry)); err != nil {
+		t.Fatalf("expected the server ID header to be covered by the signature: %v", err)
+	}
+	if err := validateServerBinding(headers, configEntry); err != nil {
+		t.Fatalf("expected the server ID header to match: %v", err)
+	}
+}
+
+func TestFilterSignedHeaders(t *testing.T) {
+	headers := testSignatureHeaders("date (request-target) host")
+	headers.Set("user-agent", "vault-cli")
+	headers.Set("x-forwarded-for", "10.0.0.1")
+
+	filtered, err := filterSignedHeaders(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(filtered) != 4 {
+		t.Fatalf("expected only the signed headers and authorization to be forwarded, got: %v", filtered)
+	}
+	for _, name := range []string{"date", HdrRequestTarget, "host", HdrAuthorization} {
+		if filtered.Get(name) != headers.Get(name) {
+			t.Fatalf("expected header %q to be forwarded", name)
+		}
+	}
+	for _, name := range []string{"user-agent", "x-forwarded-for", "x-vault-oci-test"} {
+		if _, ok := filtered[http.CanonicalHeaderKey(name)]; ok {
+			t.Fatalf("expected header %q to be dropped", name)
+		}
+	}
+}
+
+func TestValidateHeaderLimits(t *testing.T) {
+	if err := validateHeaderLimits(testSignatureHeaders("date (request-target) host")); err != nil {
+		t.Fatalf("expected the headers to be within the limits: %v", err)
+	}
+
+	headers := testSignatureHeaders("date (request-target) host")
+	for i := 0; i < MaxLoginHeaderCount; i++ {
+		headers.Set(fmt.Sprintf("x-extra-%d", i), "value")
+	}
+	if err := validateHeaderLimits(headers); err == nil {
+		t.Fatal("expected too many headers to be rejected")
+	}
+
+	headers = testSignatureHeaders("date (request-target) host")
+	headers.Set("x-large", strings.Repeat("a", MaxLoginHeaderValueSize+1))
+	if err := validateHeaderLimits(headers); err == nil || !strings.Contains(err.Error(), "x-large") {
+		t.Fatalf("expected an oversized header to be reported, got: %v", err)
+	}
+
+	headers = testSignatureHeaders("date (request-target) host")
+	headers.Set("x-large-1", strings.Repeat("a", MaxLoginHeaderValueSize))
+	headers.Set("x-large-2", strings.Repeat("a", MaxLoginHeaderValueSize))
+	if err := validateHeaderLimits(headers); err == nil {
+		t.Fatal("expected headers over the total size limit to be rejected")
+	}
+}
+
+func TestValidateSignedHeaders_Duplicates(t *testing.T) {
+	if err := validateSignedHeaders(testSignatureHeaders("date (request-target) host date"), nil); err == nil {
+		t.Fatal("expected a header listed twice in the signature to be rejected")
+	}
+
+	headers := testSignatureHeaders("date (request-target) host")
+	headers.Add("host", "vault-dev.example.com")
+	err := validateSignedHeaders(headers, nil)
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("expected a signed header with multiple values to be rejected, got: %v", err)
+	}
+}
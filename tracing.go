@@ -0,0 +1,75 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the plugin
+const tracerName = "github.com/hashicorp/vault-plugin-auth-oci"
+
+// These constants store the attribute keys of the spans of the plugin
+const (
+	attributeRoleName      = attribute.Key("oci.role_name")
+	attributePrincipalType = attribute.Key("oci.principal_type")
+	attributeOutcome       = attribute.Key("oci.outcome")
+	attributeErrorCode     = attribute.Key("oci.error_code")
+	attributeOpcRequestId  = attribute.Key("oci.opc_request_id")
+	attributeStorageKey    = attribute.Key("vault.storage_key")
+	attributeRequestId     = attribute.Key("vault.request_id")
+)
+
+// These constants store the outcomes recorded on spans
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// startSpan starts a span of the plugin. Spans are only recorded if a tracer provider was installed
+// with otel.SetTracerProvider, which the plugin binary does when an OTLP endpoint is configured.
+// Otherwise the global no-op provider is used and tracing is off.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records the outcome of the operation of a span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attributeOutcome.String(outcomeFailure))
+	} else {
+		span.SetAttributes(attributeOutcome.String(outcomeSuccess))
+	}
+	span.End()
+}
+
+// recordLoginFailure records the failure of a login on its span
+func recordLoginFailure(ctx context.Context, errorCode string, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, errorCode)
+	span.SetAttributes(attributeOutcome.String(outcomeFailure), attributeErrorCode.String(errorCode))
+}
+
+// injectTraceContext adds the W3C trace context of the span in the context to the headers of an
+// outgoing request, so that OCI can join its traces to the login. Nothing is added without a span.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// readStorage reads a storage entry in a span, for the config and role reads of a login
+func readStorage(ctx context.Context, s logical.Storage, key string) (*logical.StorageEntry, error) {
+	ctx, span := startSpan(ctx, "oci.storage.read", attributeStorageKey.String(key))
+	entry, err := s.Get(ctx, key)
+	endSpan(span, err)
+	return entry, err
+}
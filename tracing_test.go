@@ -0,0 +1,139 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useInMemoryTracing installs a tracer provider that records the spans of the test in memory
+func useInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tracerProvider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		tracerProvider.Shutdown(context.Background())
+	})
+
+	return exporter
+}
+
+// spanAttribute returns the value of an attribute of a recorded span
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestLogin_Tracing(t *testing.T) {
+	role := "testrole"
+
+	login := func(t *testing.T, groupIds ...string) *fakeIdentity {
+		b, storage := setupTestLoginBackend(t, role, nil)
+		identity := &fakeIdentity{
+			subjectId:     "ocid1.instance.oc1.phx.aaaatest",
+			principalType: PrincipalTypeInstance,
+			groupIds:      groupIds,
+		}
+		useFakeIdentity(t, b, identity)
+
+		headers := signTestLoginRequest(t, "https://vault.example.com", PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role), nil)
+		_, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + role,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if _, ok := err.(logical.HTTPCodedError); err != nil && !ok {
+			t.Fatal(err)
+		}
+		return identity
+	}
+
+	spansByName := func(exporter *tracetest.InMemoryExporter) map[string]tracetest.SpanStub {
+		spans := map[string]tracetest.SpanStub{}
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+		return spans
+	}
+
+	t.Run("Off", func(t *testing.T) {
+		identity := login(t, "ocid1")
+		if len(identity.requests["/v1/authentication/authenticateClient"]) == 0 {
+			t.Fatal("expected an authentication request")
+		}
+		for _, request := range identity.requests["/v1/authentication/authenticateClient"] {
+			if request.Header.Get("traceparent") != "" {
+				t.Fatal("expected no trace context to be sent without a tracer provider")
+			}
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		exporter := useInMemoryTracing(t)
+		identity := login(t, "ocid1")
+
+		spans := spansByName(exporter)
+		loginSpan, ok := spans["oci.login"]
+		if !ok {
+			t.Fatalf("expected a login span, got: %v", spans)
+		}
+		if spanAttribute(loginSpan, attributeRoleName) != role ||
+			spanAttribute(loginSpan, attributePrincipalType) != PrincipalTypeInstance ||
+			spanAttribute(loginSpan, attributeOutcome) != outcomeSuccess {
+			t.Fatalf("unexpected login span attributes: %v", loginSpan.Attributes)
+		}
+
+		for _, name := range []string{"oci.identity.AuthenticateClient", "oci.identity.FilterGroupMembership", "oci.storage.read"} {
+			span, ok := spans[name]
+			if !ok {
+				t.Fatalf("expected a %s span, got: %v", name, spans)
+			}
+			if span.Parent.SpanID() != loginSpan.SpanContext.SpanID() {
+				t.Fatalf("expected the %s span to be part of the login trace", name)
+			}
+		}
+
+		// The W3C trace context of the login is sent to OCI Identity
+		requests := identity.requests["/v1/authentication/authenticateClient"]
+		if len(requests) != 1 {
+			t.Fatalf("expected one authentication request, got %d", len(requests))
+		}
+		expected := fmt.Sprintf("00-%s-%s-01", loginSpan.SpanContext.TraceID(), spans["oci.identity.AuthenticateClient"].SpanContext.SpanID())
+		if traceparent := requests[0].Header.Get("traceparent"); traceparent != expected {
+			t.Fatalf("expected traceparent %q, got %q", expected, traceparent)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		exporter := useInMemoryTracing(t)
+		login(t, "ocid9")
+
+		loginSpan, ok := spansByName(exporter)["oci.login"]
+		if !ok {
+			t.Fatal("expected a login span")
+		}
+		if spanAttribute(loginSpan, attributeOutcome) != outcomeFailure ||
+			spanAttribute(loginSpan, attributeErrorCode) != ErrorCodeAccessDenied {
+			t.Fatalf("unexpected login span attributes: %v", loginSpan.Attributes)
+		}
+	})
+}
@@ -0,0 +1,13 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+
+// Package version holds the version of the plugin, which is set at build time
+package version
+
+var (
+	// Version is the version of the plugin reported to Vault. Release builds set it with
+	// -ldflags "-X github.com/hashicorp/vault-plugin-auth-oci/version.Version=v0.20.1"
+	Version = "v0.20.1-dev"
+
+	// GitCommit is the commit the plugin was built from
+	GitCommit = ""
+)